@@ -2,7 +2,10 @@ module github.com/benc-uk/gozm
 
 go 1.25.0
 
-require github.com/peterh/liner v1.2.2
+require (
+	github.com/peterh/liner v1.2.2
+	golang.org/x/term v0.38.0
+)
 
 require (
 	github.com/mattn/go-runewidth v0.0.3 // indirect
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/benc-uk/gozm/internal/blorb"
+	"github.com/benc-uk/gozm/internal/disasm"
+	"github.com/benc-uk/gozm/internal/zmachine"
+)
+
+// Subcommands for looking inside a story rather than playing it, given as the first argument
+var commands = map[string]func(args []string) int{
+	"disasm": disasmCommand,
+	"dump":   dumpCommand,
+}
+
+// disasmCommand lists the Z-code of a story, e.g. gozm disasm zork1.z3
+func disasmCommand(args []string) int {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: gozm disasm <story file>\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	story, err := loadStory(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	d, err := disasm.New(story)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	d.Write(os.Stdout)
+	return 0
+}
+
+// dumpCommand shows the header, objects, dictionary and other tables of a story, as text or JSON
+func dumpCommand(args []string) int {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Output JSON rather than plain text")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: gozm dump [-json] <story file>\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	fileName := flags.Arg(0)
+	story, err := loadStory(fileName)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	// The machine is only created to read its tables, so it gets a terminal that never takes input
+	name := strings.TrimSuffix(path.Base(fileName), path.Ext(fileName))
+	machine := zmachine.NewMachine(story, name, zmachine.DEBUG_NONE, &Terminal{name: name})
+	dump := machine.Dump()
+
+	if !*asJSON {
+		dump.WriteText(os.Stdout)
+		return 0
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(dump); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	return 0
+}
+
+// Reads a story file, getting the story out of it if it's a Blorb file
+func loadStory(fileName string) ([]byte, error) {
+	if fileName == "" {
+		return nil, fmt.Errorf("no story file specified")
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+
+	story, _, err := blorb.Unwrap(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Blorb file: %w", err)
+	}
+
+	if len(story) < 64 || story[0x00] < 1 || story[0x00] > 8 {
+		return nil, fmt.Errorf("%s is not a Z-machine story file", fileName)
+	}
+
+	return story, nil
+}
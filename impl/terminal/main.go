@@ -5,22 +5,55 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 
+	"github.com/benc-uk/gozm/internal/blorb"
 	"github.com/benc-uk/gozm/internal/zmachine"
 )
 
 var version = "0.0.0"
 
 func main() {
+	// Subcommands like disasm don't run the game, so are dealt with before anything else
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
 	info("GOZM: Go Z-Machine Runtime and VM v%s\n", version)
 
 	debugLevel := 0
 	fileName := ""
+	scriptFile := ""
+	errorPolicy := "once"
+	var seed uint64
+	startDebugger := false
 	flag.IntVar(&debugLevel, "debug", zmachine.DEBUG_NONE, "Set debug level (0=none, 1=step, 2=trace)")
 	flag.StringVar(&fileName, "file", "", "Path to Z-machine story file to load")
 	flag.StringVar(&fileName, "f", "", "Path to Z-machine story file to load")
+	flag.StringVar(&scriptFile, "script", "", "Path to a file of commands to play back before using the keyboard")
+	flag.StringVar(&errorPolicy, "errors", "once", "How to deal with errors in the game (none, once, always, fatal)")
+	flag.Uint64Var(&seed, "seed", 0, "Seed for random numbers so games play the same every time, 0 is truly random")
+	flag.BoolVar(&startDebugger, "debugger", false, "Start in the interactive debugger, stopped at the first instruction")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: gozm [flags] <story file>\n       gozm disasm <story file>\n       gozm dump [-json] <story file>\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	policies := map[string]int{
+		"none":   zmachine.ERRORS_IGNORE,
+		"once":   zmachine.ERRORS_ONCE,
+		"always": zmachine.ERRORS_ALWAYS,
+		"fatal":  zmachine.ERRORS_FATAL,
+	}
+	policy, ok := policies[errorPolicy]
+	if !ok {
+		fmt.Printf("Invalid error policy %s, must be none, once, always or fatal\n", errorPolicy)
+		os.Exit(1)
+	}
+
 	if debugLevel < 0 || debugLevel > 2 {
 		fmt.Printf("Invalid debug level %d, must be 0, 1, or 2\n", debugLevel)
 		os.Exit(1)
@@ -42,12 +75,90 @@ func main() {
 		os.Exit(1)
 	}
 
-	ext := NewTerminal()
+	// The story might be packaged in a Blorb file, along with its pictures and sounds
+	data, blorbFile, err := blorb.Unwrap(data)
+	if err != nil {
+		fmt.Printf("Error reading Blorb file: %s\n", err)
+		os.Exit(1)
+	}
+	if blorbFile != nil && blorbFile.Title() != "" {
+		info("Loaded %s\n", blorbFile.Title())
+	}
+
 	filenameOnly := path.Base(fileName)
 	filenameOnly = filenameOnly[:len(filenameOnly)-len(path.Ext(filenameOnly))]
+
+	// Older games had their sounds in a separate Blorb file, found next to the story
+	if blorbFile == nil {
+		blorbFile = loadBlorb(strings.TrimSuffix(fileName, path.Ext(fileName)) + ".blb")
+	}
+
+	ext := NewTerminal(filenameOnly)
 	machine := zmachine.NewMachine(data, filenameOnly, debugLevel, ext)
 
-	exitCode := machine.Run()
+	machine.SetBlorb(blorbFile)
+	machine.SetErrorPolicy(policy)
+	machine.SetRandomSeed(seed)
+	if startDebugger {
+		machine.StartDebugger()
+	}
+
+	if scriptFile != "" {
+		ext.scriptPath = scriptFile
+		machine.SetInputStream(zmachine.INPUT_STREAM_FILE)
+	}
+
+	exitCode, err := machine.Run()
+	for err != nil && recoverFromError(ext, machine, err) {
+		exitCode, err = machine.Run()
+	}
+
+	ext.Close()
 	fmt.Printf("Program exited with code %d\n", exitCode)
 	os.Exit(exitCode - 1)
 }
+
+// Loads a Blorb file holding resources for the story, nil if there isn't one
+func loadBlorb(fileName string) *blorb.Blorb {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil
+	}
+
+	b, err := blorb.Load(data)
+	if err != nil {
+		fmt.Printf("Ignoring resources in %s: %s\n", fileName, err)
+		return nil
+	}
+
+	info("Loaded resources from %s\n", fileName)
+	return b
+}
+
+// After a runtime error the player can go back to before their last command and carry on,
+// or save that point to come back to. Returns true if the game should be run again
+func recoverFromError(ext *Terminal, machine *zmachine.Machine, err error) bool {
+	fmt.Printf("\n💥 %s\n", err)
+	if rerr, ok := err.(*zmachine.RuntimeError); ok {
+		fmt.Printf("Stack trace:\n%s", rerr.StackTrace())
+	}
+
+	ext.TextOut("\n[C]arry on from before your last command, [S]ave that point and quit, or [Q]uit? ")
+	answer, _ := ext.ReadInput(0)
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "c":
+		if machine.RollBack() {
+			return true
+		}
+		fmt.Println("There is no earlier point to go back to")
+	case "s":
+		// The game is run again just to save itself, using its own save command, and then quit
+		if machine.RollBack() {
+			machine.QueueInput("/save", "/quit")
+			return true
+		}
+	}
+
+	return false
+}
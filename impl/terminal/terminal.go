@@ -3,23 +3,61 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
 	"strings"
-
-	"encoding/json"
+	"time"
+	"unicode"
 
 	"github.com/benc-uk/gozm/internal/zmachine"
 	"github.com/peterh/liner"
+	"golang.org/x/term"
 )
 
 // Implements a simple terminal interface for Z-machine IO
 type Terminal struct {
 	liner         *liner.State
-	pendingPrompt string // Text output that didn't end with newline (used as prompt)
+	stdin         *bufio.Reader        // Used when liner isn't available or stdin isn't a terminal
+	pendingPrompt string               // Text output that didn't end with newline (used as prompt)
+	name          string               // Name of the story, used to name the files we write
+	transcript    *os.File             // Transcript file, opened the first time it's needed
+	commands      *os.File             // Command record file, opened the first time it's needed
+	scriptPath    string               // Script of commands to play back, set with the -script flag
+	isTTY         bool                 // Only draw the status bar when stdout is a real terminal
+	hasStatus     bool                 // Has the game drawn a status line
+	upperLines    [][]zmachine.TextRun // Contents of the upper window, empty when the screen isn't split
+	regionTop     int                  // First row of the scroll region, 0 if one hasn't been set
+	rows          int                  // Terminal height when the scroll region was last set
+	lines         chan string          // Line being read in the background, nil when no read is pending
+	dropLine      bool                 // The pending line was for an abandoned read, so isn't wanted
+	keys          chan rune            // Key being read in the background, nil when no read is pending
+	keyInput      bool                 // Stdin is a real terminal, so keys can be read one at a time
+	style         int                  // Lower window text style, kept as setting colours resets it
+	fg, bg        int                  // Lower window text colours, kept as setting a style resets them
+	edit          lineEdit             // Line being typed a key at a time, for timed reads
+}
+
+// lineEdit is a line of input being typed, which can be left and carried on with later
+type lineEdit struct {
+	active bool   // A line is being typed
+	prompt string // Prompt shown before the typed text
+	typed  []rune // What's been typed so far
+	shown  bool   // The prompt & typed text are on the screen, output since means a redraw
 }
 
-func NewTerminal() *Terminal {
-	t := &Terminal{}
+// Matches the escape codes SetTextStyle adds to the output, which liner can't have in a prompt
+var styleCodes = regexp.MustCompile("\033\\[[0-9;]*m")
+
+func NewTerminal(name string) *Terminal {
+	t := &Terminal{
+		name:     name,
+		stdin:    bufio.NewReader(os.Stdin),
+		isTTY:    term.IsTerminal(int(os.Stdout.Fd())),
+		keyInput: term.IsTerminal(int(os.Stdin.Fd())),
+		fg:       zmachine.COLOUR_DEFAULT,
+		bg:       zmachine.COLOUR_DEFAULT,
+	}
 
 	// Try to create a liner instance for better UX (arrow-key history)
 	l := liner.NewLiner()
@@ -33,6 +71,17 @@ func NewTerminal() *Terminal {
 // TextOut outputs text to the console
 // This is made more complex by the need to track text that might be a prompt
 func (t *Terminal) TextOut(text string) {
+	// Output while a line is part typed goes below it, the line is drawn again when reading resumes
+	if t.edit.shown {
+		text = "\n" + text
+		t.edit.shown = false
+	}
+
+	// Waiting for a key leaves the terminal in raw mode, where a newline doesn't return the cursor
+	if t.keys != nil {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+
 	// Track text that doesn't end with newline as pending prompt
 	if strings.HasSuffix(text, "\n") {
 		// Has newline - print everything and clear pending prompt
@@ -54,12 +103,122 @@ func (t *Terminal) TextOut(text string) {
 	os.Stdout.Sync()
 }
 
-// ReadInput reads a line of input from the console
-func (t *Terminal) ReadInput() string {
+// ReadInput reads a line of input from the console, giving up after the timeout if there is one
+// A read that times out carries on in the background and is picked up by the next call
+// On a terminal timed reads are typed a key at a time, as liner can't be interrupted
+func (t *Terminal) ReadInput(timeout time.Duration) (string, bool) {
+	if t.keyInput && (timeout != 0 || t.edit.active || t.keys != nil) {
+		return t.editLine(timeout)
+	}
+
+	var expired <-chan time.Time
+	if timeout != 0 {
+		expired = time.After(timeout)
+	}
+
+	for {
+		if t.lines == nil {
+			t.lines = make(chan string, 1)
+			prompt := t.pendingPrompt
+			t.pendingPrompt = ""
+			go func() { t.lines <- t.readLine(prompt) }()
+		}
+
+		select {
+		case line := <-t.lines:
+			t.lines = nil
+			if t.dropLine {
+				t.dropLine = false
+				continue
+			}
+			return line, true
+		case <-expired:
+			return "", false
+		}
+	}
+}
+
+// AbandonInput throws away a timed read the game has given up on, so what was
+// typed for it doesn't turn up in the next read
+func (t *Terminal) AbandonInput() {
+	if t.lines != nil {
+		t.dropLine = true
+	}
+
+	if t.edit.active {
+		if t.edit.shown {
+			t.TextOut("")
+		}
+		t.edit = lineEdit{}
+	}
+}
+
+// Types a line a key at a time, giving up after the timeout if there is one
+// What's been typed is kept when the time runs out, and is drawn again if there's been output since
+func (t *Terminal) editLine(timeout time.Duration) (string, bool) {
+	if !t.edit.active {
+		t.edit = lineEdit{active: true, prompt: t.pendingPrompt}
+		t.pendingPrompt = ""
+	}
+
+	if !t.edit.shown {
+		// Output since the line was last drawn may not have ended its line, e.g. from an interrupt routine
+		if t.pendingPrompt != "" {
+			t.TextOut("\n")
+		}
+		fmt.Print(t.edit.prompt + string(t.edit.typed))
+		os.Stdout.Sync()
+		t.edit.shown = true
+	}
+
+	var expired <-chan time.Time
+	if timeout != 0 {
+		expired = time.After(timeout)
+	}
+
+	for {
+		if t.keys == nil {
+			t.keys = make(chan rune, 1)
+			go func() { t.keys <- t.readKey() }()
+		}
+
+		select {
+		case key := <-t.keys:
+			t.keys = nil
+
+			switch {
+			case key == '\r' || key == '\n':
+				line := string(t.edit.typed)
+				t.edit = lineEdit{}
+				fmt.Print("\n")
+				if t.liner != nil && line != "" {
+					t.liner.AppendHistory(line)
+				}
+				return line + "\n", true
+
+			case key == zmachine.KEY_DELETE:
+				if len(t.edit.typed) > 0 {
+					t.edit.typed = t.edit.typed[:len(t.edit.typed)-1]
+					fmt.Print("\b \b")
+				}
+
+			case unicode.IsPrint(key):
+				t.edit.typed = append(t.edit.typed, key)
+				fmt.Print(string(key))
+			}
+			os.Stdout.Sync()
+
+		case <-expired:
+			return "", false
+		}
+	}
+}
+
+func (t *Terminal) readLine(prompt string) string {
 	// If liner is available, use it to provide history navigation
 	if t.liner != nil {
-		line, err := t.liner.Prompt(t.pendingPrompt)
-		t.pendingPrompt = ""
+		line, err := t.liner.Prompt(styleCodes.ReplaceAllString(prompt, ""))
+		prompt = ""
 		if err == nil {
 			if len(line) > 0 {
 				t.liner.AppendHistory(line)
@@ -69,36 +228,333 @@ func (t *Terminal) ReadInput() string {
 		// If liner errors (e.g., EOF/Ctrl-C), fall back to stdio
 	}
 
-	// Print the pending prompt before reading
-	if t.pendingPrompt != "" {
+	// Print the prompt before reading
+	if prompt != "" {
+		fmt.Print(prompt)
+		os.Stdout.Sync()
+	}
+
+	text, _ := t.stdin.ReadString('\n')
+	return text
+}
+
+// ReadChar waits for a single key press, giving up after the timeout if there is one
+func (t *Terminal) ReadChar(timeout time.Duration) (rune, bool) {
+	if t.keys == nil {
+		t.keys = make(chan rune, 1)
 		fmt.Print(t.pendingPrompt)
 		t.pendingPrompt = ""
 		os.Stdout.Sync()
+		go func() { t.keys <- t.readKey() }()
 	}
 
-	reader := bufio.NewReader(os.Stdin)
-	text, _ := reader.ReadString('\n')
-	return text
+	if timeout == 0 {
+		key := <-t.keys
+		t.keys = nil
+		return key, true
+	}
+
+	select {
+	case key := <-t.keys:
+		t.keys = nil
+		return key, true
+	case <-time.After(timeout):
+		return 0, false
+	}
 }
 
-func (t *Terminal) PlaySound(soundID uint16, effect uint16, volume uint16) {
-	info("Playing sound ID:%d effect:%d volume:%d\n", soundID, effect, volume)
+// Reads one key with the terminal in raw mode, cursor keys arrive as escape sequences
+func (t *Terminal) readKey() rune {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		r, _, err := t.stdin.ReadRune()
+		if err != nil {
+			return '\n'
+		}
+		return r
+	}
+
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return '\n'
+	}
+	defer func() { _ = term.Restore(fd, oldState) }()
+
+	buf := make([]byte, 8)
+	n, err := os.Stdin.Read(buf)
+	if err != nil || n == 0 {
+		return '\n'
+	}
+
+	switch {
+	case buf[0] == 3: // Ctrl-C, raw mode means we have to handle this ourselves
+		_ = term.Restore(fd, oldState)
+		t.Close()
+		os.Exit(1)
+	case buf[0] == 127:
+		return zmachine.KEY_DELETE
+	case n >= 3 && buf[0] == 27 && buf[1] == '[':
+		switch buf[2] {
+		case 'A':
+			return zmachine.KEY_UP
+		case 'B':
+			return zmachine.KEY_DOWN
+		case 'C':
+			return zmachine.KEY_RIGHT
+		case 'D':
+			return zmachine.KEY_LEFT
+		}
+	}
+
+	r := []rune(string(buf[:n]))
+	return r[0]
 }
 
-// Save saves a snapshot of the machine state to a JSON file on disk
-func (t *Terminal) Save(state *zmachine.SaveState) bool {
-	// Snapshot all of the machine state to a file
-	savePath := getSaveFullPath(state.Name)
-	file, err := os.Create(savePath)
+// TranscriptOut appends text to the transcript file, which is only opened once per session
+func (t *Terminal) TranscriptOut(text string) {
+	if t.transcript == nil {
+		t.transcript = openAppend(getFullPath(t.name, ".txt"))
+		if t.transcript == nil {
+			return
+		}
+		info("Transcript being written to %s\n", t.transcript.Name())
+	}
+
+	_, _ = t.transcript.WriteString(text)
+}
+
+// CommandOut appends a player's command to the command record file
+func (t *Terminal) CommandOut(command string) {
+	if t.commands == nil {
+		t.commands = openAppend(getFullPath(t.name, ".rec"))
+		if t.commands == nil {
+			return
+		}
+		info("Commands being recorded to %s\n", t.commands.Name())
+	}
+
+	_, _ = t.commands.WriteString(command + "\n")
+}
+
+// CloseStream closes the transcript or command record file when its stream is deselected
+// It's opened again if the stream is selected again, and written to after what's already there
+func (t *Terminal) CloseStream(stream int) {
+	switch stream {
+	case zmachine.OUTPUT_STREAM_TRANSCRIPT:
+		closeFile(&t.transcript)
+	case zmachine.OUTPUT_STREAM_COMMANDS:
+		closeFile(&t.commands)
+	}
+}
+
+// OpenScript opens a script of commands to be played back, one per line
+// This is the file given with -script, or failing that the commands recorded last time
+func (t *Terminal) OpenScript(name string) io.Reader {
+	scriptPath := t.scriptPath
+	if scriptPath == "" {
+		scriptPath = getFullPath(name, ".rec")
+	}
+
+	file, err := os.Open(scriptPath)
 	if err != nil {
-		fmt.Printf("Error creating save file: %v\n", err)
-		return false
+		fmt.Printf("Error opening script file: %v\n", err)
+		return nil
+	}
+
+	// The -script file is only played once, after that we fall back to the recording
+	t.scriptPath = ""
+	info("Playing commands from %s\n", scriptPath)
+	return file
+}
+
+// ShowStatus draws the status line as a reverse video bar fixed to the top of the terminal
+func (t *Terminal) ShowStatus(status zmachine.StatusLine) {
+	if !t.isTTY {
+		return
+	}
+
+	// The upper window might have been drawn already, in which case it needs to move down
+	moveUpper := !t.hasStatus && len(t.upperLines) > 0
+	t.hasStatus = true
+	width, ok := t.setRegion()
+	if !ok {
+		return
+	}
+
+	if moveUpper {
+		t.drawUpper(width, len(t.upperLines))
+	}
+
+	left := " " + status.Location
+	right := status.Summary() + " "
+	space := width - len(right)
+	if len(left) > space-1 {
+		left = left[:max(space-1, 0)]
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(state); err != nil {
-		fmt.Printf("Error encoding save data: %v\n", err)
+	line := left + strings.Repeat(" ", max(space-len(left), 0)) + right
+	fmt.Printf("\0337\033[1;1H\033[7m%s\033[0m\0338", line)
+	os.Stdout.Sync()
+}
+
+// ShowUpperWindow draws the upper window, fixed below the status line if there is one
+func (t *Terminal) ShowUpperWindow(lines [][]zmachine.TextRun) {
+	if !t.isTTY {
+		return
+	}
+
+	// Rows which the upper window no longer covers will need blanking
+	oldLines := len(t.upperLines)
+	t.upperLines = lines
+	width, ok := t.setRegion()
+	if !ok {
+		return
+	}
+
+	t.drawUpper(width, oldLines)
+}
+
+// Draws the upper window lines, blanking any rows down to clearLines which are no longer used
+func (t *Terminal) drawUpper(width int, clearLines int) {
+	top := 1
+	if t.hasStatus {
+		top = 2
+	}
+
+	fmt.Print("\0337")
+	for i := 0; i < max(len(t.upperLines), clearLines); i++ {
+		fmt.Printf("\033[%d;1H\033[2K", top+i)
+		if i < len(t.upperLines) {
+			drawRuns(t.upperLines[i], width)
+		}
+	}
+	fmt.Print("\0338")
+	os.Stdout.Sync()
+}
+
+// ClearLowerWindow blanks everything below the status line and upper window
+func (t *Terminal) ClearLowerWindow() {
+	t.pendingPrompt = ""
+	if !t.isTTY {
+		return
+	}
+
+	fmt.Printf("\033[%d;1H\033[J", max(t.regionTop, 1))
+	os.Stdout.Sync()
+}
+
+// SetTextStyle switches the style of the text which follows, fixed pitch is ignored as we always are
+func (t *Terminal) SetTextStyle(style int) {
+	if !t.isTTY {
+		return
+	}
+
+	t.style = style
+	t.TextOut(styleCode(style) + colourCode(t.fg, t.bg))
+}
+
+// SetColour switches the colours of the text which follows, the default is the terminal's own
+func (t *Terminal) SetColour(fg int, bg int) {
+	if !t.isTTY {
+		return
+	}
+
+	t.fg, t.bg = fg, bg
+	t.TextOut(styleCode(t.style) + colourCode(fg, bg))
+}
+
+// setRegion keeps the scroll region below the status line and upper window
+// Returns the terminal width, and false if the terminal is too small to bother
+func (t *Terminal) setRegion() (int, bool) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width < 10 {
+		return 0, false
+	}
+
+	top := 1 + len(t.upperLines)
+	if t.hasStatus {
+		top++
+	}
+
+	if top >= height {
+		return 0, false
+	}
+
+	if top == t.regionTop && height == t.rows {
+		return width, true
+	}
+
+	if t.regionTop == 0 && top > 1 {
+		// First time, scroll everything up so the fixed rows don't cover any text
+		fmt.Printf("\033[%dS\033[%dA", top-1, top-1)
+	}
+
+	// Setting the scroll region moves the cursor, so it's saved & restored around it
+	fmt.Printf("\0337\033[%d;%dr\0338", top, height)
+	t.regionTop = top
+	t.rows = height
+
+	return width, true
+}
+
+// Close puts the terminal back how we found it
+func (t *Terminal) Close() {
+	if t.liner != nil {
+		_ = t.liner.Close()
+	}
+
+	closeFile(&t.transcript)
+	closeFile(&t.commands)
+
+	// Styles & colours the game left on shouldn't carry on after we've gone
+	if t.isTTY && (t.style != zmachine.STYLE_ROMAN || t.fg != zmachine.COLOUR_DEFAULT || t.bg != zmachine.COLOUR_DEFAULT) {
+		fmt.Print("\033[0m")
+	}
+
+	if t.regionTop > 0 {
+		fmt.Printf("\033[r\033[%d;1H", t.rows)
+		t.regionTop = 0
+	}
+}
+
+// PlaySound can't play the audio in a terminal, so it just says what it would play
+func (t *Terminal) PlaySound(sound zmachine.Sound) {
+	info("[Sound %d: %s, %d bytes, volume %d, repeats %d]\n", sound.Number, sound.Format, len(sound.Data), sound.Volume, sound.Repeats)
+}
+
+func (t *Terminal) StopSound() {}
+
+// Bleep rings the terminal bell, which is as close as we can get to a high or low bleep
+func (t *Terminal) Bleep(high bool) {
+	fmt.Print("\a")
+}
+
+// Capabilities tells the machine what the terminal can do, the width is taken from the
+// terminal if there is one, otherwise the default is used
+func (t *Terminal) Capabilities() zmachine.Capabilities {
+	caps := zmachine.Capabilities{
+		StatusLine:  true,
+		SplitScreen: true,
+		Styles:      true,
+		FixedPitch:  true,
+		TimedInput:  true,
+		Colours:     t.isTTY,
+	}
+
+	if t.isTTY {
+		if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			caps.Width = width
+		}
+	}
+
+	return caps
+}
+
+// Save writes a Quetzal save file to disk
+func (t *Terminal) Save(name string, data []byte) bool {
+	savePath := getFullPath(name, ".qzl")
+	if err := os.WriteFile(savePath, data, 0o600); err != nil {
+		fmt.Printf("Error writing save file: %v\n", err)
 		return false
 	}
 
@@ -106,38 +562,102 @@ func (t *Terminal) Save(state *zmachine.SaveState) bool {
 	return true
 }
 
-// Load loads a saved machine state from a JSON file on disk
-func (t *Terminal) Load(name string, machine *zmachine.Machine) bool {
-	savePath := getSaveFullPath(name)
-	file, err := os.Open(savePath)
+// Load reads a Quetzal save file from disk
+func (t *Terminal) Load(name string) []byte {
+	savePath := getFullPath(name, ".qzl")
+	data, err := os.ReadFile(savePath)
 	if err != nil {
 		fmt.Printf("Error opening save file: %v\n", err)
-		return false
+		return nil
 	}
-	defer file.Close()
 
-	// Decode saved state
-	var saveData zmachine.SaveState
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&saveData); err != nil {
-		fmt.Printf("Error decoding save data: %v\n", err)
-		return false
+	info("Game loaded from %s\n", savePath)
+	return data
+}
+
+// Cuts a string down to fit in the given number of columns
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if len(r) > width {
+		return string(r[:width])
 	}
+	return s
+}
 
-	// Restore machine state
-	machine.ReplaceState(&saveData)
-	info("Game loaded from %s\n", savePath)
-	return true
+// Prints a line of styled text, cut down to fit in the given number of columns
+func drawRuns(runs []zmachine.TextRun, width int) {
+	for _, run := range runs {
+		if width <= 0 {
+			break
+		}
+
+		text := truncate(run.Text, width)
+		width -= len([]rune(text))
+		fmt.Print(styleCode(run.Style) + text)
+	}
+	fmt.Print("\033[0m")
+}
+
+// ANSI escape code for a combination of zmachine.STYLE_* bits
+func styleCode(style int) string {
+	code := "\033[0"
+	if style&zmachine.STYLE_BOLD != 0 {
+		code += ";1"
+	}
+	if style&zmachine.STYLE_ITALIC != 0 {
+		code += ";3"
+	}
+	if style&zmachine.STYLE_REVERSE != 0 {
+		code += ";7"
+	}
+	return code + "m"
+}
+
+// Escape code for Z-machine colours, which run from black to white in the same order as ANSI's
+func colourCode(fg int, bg int) string {
+	code := ""
+	if fg >= zmachine.COLOUR_BLACK {
+		code += fmt.Sprintf(";%d", 30+fg-zmachine.COLOUR_BLACK)
+	}
+	if bg >= zmachine.COLOUR_BLACK {
+		code += fmt.Sprintf(";%d", 40+bg-zmachine.COLOUR_BLACK)
+	}
+	if code == "" {
+		return ""
+	}
+	return "\033[" + code[1:] + "m"
 }
 
 func info(format string, a ...interface{}) {
 	fmt.Printf("\033[34m"+format+"\033[0m", a...)
 }
 
-func getSaveFullPath(name string) string {
+// Files we write, saves, transcripts etc, all live in the home directory
+func getFullPath(name string, ext string) string {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return ""
 	}
-	return homeDir + "/" + name + ".save"
+	return homeDir + "/" + name + ext
+}
+
+func openAppend(path string) *os.File {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		fmt.Printf("Error opening %s: %v\n", path, err)
+		return nil
+	}
+	return file
+}
+
+// Closes a file we've been writing to, if it's open, so the next write opens it again
+func closeFile(file **os.File) {
+	if *file == nil {
+		return
+	}
+
+	if err := (*file).Close(); err != nil {
+		fmt.Printf("Error closing %s: %v\n", (*file).Name(), err)
+	}
+	*file = nil
 }
@@ -11,6 +11,7 @@ import (
 	"syscall/js"
 	"time"
 
+	"github.com/benc-uk/gozm/internal/blorb"
 	"github.com/benc-uk/gozm/internal/zmachine"
 )
 
@@ -53,9 +54,11 @@ func main() {
 	ext = NewWebExternal()
 	bridge = js.Global().Get("bridge")
 	bridge.Set("inputSend", js.FuncOf(ext.receiveInput))
+	bridge.Set("charSend", js.FuncOf(ext.receiveChar))
 	bridge.Set("receiveFileData", js.FuncOf(receiveFileData))
 	bridge.Set("save", js.FuncOf(save))
 	bridge.Set("load", js.FuncOf(load))
+	bridge.Set("undo", js.FuncOf(undo))
 	bridge.Set("getInfo", js.FuncOf(getInfo))
 
 	var data []byte
@@ -96,38 +99,83 @@ func main() {
 	}
 	ext.TextOut("\n")
 
+	// The story might be packaged in a Blorb file, along with its cover, pictures and sounds
+	data, blorbFile, err := blorb.Unwrap(data)
+	if err != nil {
+		bridge.Call("showModal", fmt.Sprintf("Unable to load the Blorb file:\n\n%s", err))
+		return
+	}
+	if blorbFile != nil {
+		showCover(blorbFile)
+	}
+
 	bridge.Call("loadedFile", file)
 
 	filenameOnly := path.Base(file)
 	filenameOnly = filenameOnly[:len(filenameOnly)-len(path.Ext(filenameOnly))]
 
+	// Older games had their sounds in a separate Blorb file, served alongside the story
+	if blorbFile == nil && file != "tempFile" {
+		blorbFile = fetchBlorb("stories/" + filenameOnly + ".blb")
+	}
+
 	machine = zmachine.NewMachine(data, filenameOnly, zmachine.DEBUG_NONE, ext)
+	machine.SetBlorb(blorbFile)
 
 	// Everything is about this one line
-	exitCode := machine.Run()
+	exitCode, err := machine.Run()
+
+	// Show runtime errors in a dialog rather than leaving a dead page, and carry on if we can
+	for err != nil {
+		msg := fmt.Sprintf("The game hit an error it can't recover from:\n\n%s", err)
+		if !machine.RollBack() {
+			bridge.Call("showModal", msg)
+			break
+		}
 
-	if exitCode == zmachine.EXIT_RESTART {
-		ext.TextOut("Restarting the game...\n")
-		// For web, we just reload the page
-		js.Global().Get("location").Call("reload")
-		return
+		bridge.Call("showModal", msg+"\n\nIt has been taken back to before your last command.")
+		exitCode, err = machine.Run()
 	}
 
 	fmt.Printf("Game exited with code: %d\n", exitCode)
 	os.Exit(exitCode - 1)
 }
 
+// Fetches a Blorb file holding resources for the story, nil if there isn't one
+func fetchBlorb(url string) *blorb.Blorb {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil
+	}
+
+	b, err := blorb.Load(data)
+	if err != nil {
+		fmt.Printf("Ignoring resources in %s: %s\n", url, err)
+		return nil
+	}
+
+	return b
+}
+
 func save(this js.Value, args []js.Value) interface{} {
 	if machine == nil || ext == nil {
 		fmt.Println("No machine or external interface available for saving")
 		return nil
 	}
 
-	success := ext.Save(machine.GetSaveState())
-	if success {
-		ext.TextOut("Game saved successfully.\n")
-	} else {
-		ext.TextOut("Error saving game.\n")
+	// The game is given the /save command, so it saves itself in the standard Quetzal form
+	if !ext.sendInput("/save") {
+		ext.TextOut("The game can only be saved when it's waiting for a command.\n")
 	}
 
 	return nil
@@ -139,9 +187,11 @@ func load(this js.Value, args []js.Value) interface{} {
 		return nil
 	}
 
-	loadOK := ext.Load(machine.GetName(), machine)
+	loadOK := machine.Restore()
 	if loadOK {
 		ext.TextOut("Game loaded successfully.\n")
+		// The machine is blocked waiting for input, let it carry on from the restored state
+		ext.cancelInput()
 	} else {
 		ext.TextOut("Error loading game.\n")
 	}
@@ -149,9 +199,43 @@ func load(this js.Value, args []js.Value) interface{} {
 	return nil
 }
 
+func undo(this js.Value, args []js.Value) interface{} {
+	if machine == nil || ext == nil {
+		fmt.Println("No machine or external interface available for undo")
+		return nil
+	}
+
+	if machine.Undo() {
+		ext.TextOut("Previous turn undone.\n")
+		// The machine is blocked waiting for input, let it carry on from the undone state
+		ext.cancelInput()
+	} else {
+		ext.TextOut("Nothing to undo.\n")
+	}
+
+	return nil
+}
+
 func getInfo(this js.Value, args []js.Value) interface{} {
 	if machine == nil {
 		return "No machine available to print info.\n"
 	}
 	return machine.GetInfo()
 }
+
+// Shows the cover image of a Blorb file, if it has one, along with its title
+func showCover(b *blorb.Blorb) {
+	cover := b.Cover()
+	if cover == nil {
+		return
+	}
+
+	mimeType := "image/png"
+	if cover.Type == "JPEG" {
+		mimeType = "image/jpeg"
+	}
+
+	data := js.Global().Get("Uint8Array").New(len(cover.Data))
+	js.CopyBytesToJS(data, cover.Data)
+	bridge.Call("showCover", b.Title(), mimeType, data)
+}
@@ -3,10 +3,12 @@
 package main
 
 import (
-	"encoding/json"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"strings"
 	"syscall/js"
+	"time"
 
 	"github.com/benc-uk/gozm/internal/zmachine"
 )
@@ -17,6 +19,8 @@ const MAX_HISTORY = 20
 type WebExternal struct {
 	inputChan    chan string
 	inputWaiting bool
+	charChan     chan rune
+	charWaiting  bool
 	history      []string
 	bridge       js.Value
 }
@@ -24,6 +28,7 @@ type WebExternal struct {
 func NewWebExternal() *WebExternal {
 	ext := &WebExternal{
 		inputChan: make(chan string, 1),
+		charChan:  make(chan rune, 1),
 		history:   make([]string, 0),
 		bridge:    js.Global().Get("bridge"),
 	}
@@ -35,20 +40,44 @@ func (w *WebExternal) TextOut(text string) {
 	w.bridge.Call("textOut", text)
 }
 
-func (w *WebExternal) ReadInput() string {
-	w.inputWaiting = true
+func (w *WebExternal) TranscriptOut(text string) {
+	w.bridge.Call("transcriptOut", text)
+}
 
-	// Convert []string -> []interface{} for syscall/js.ValueOf
-	hIface := make([]interface{}, len(w.history))
-	for i, v := range w.history {
-		hIface[i] = v
-	}
+func (w *WebExternal) CommandOut(command string) {
+	w.bridge.Call("commandOut", command)
+}
+
+// CloseStream has nothing to do, the transcript & commands are kept by the page to download
+func (w *WebExternal) CloseStream(stream int) {}
+
+// ReadInput waits for a line from the page, giving up after the timeout if there is one
+// A timed out read is left active on the page, so the player doesn't lose what they've typed
+func (w *WebExternal) ReadInput(timeout time.Duration) (string, bool) {
+	if !w.inputWaiting {
+		w.inputWaiting = true
 
-	// Request input from the page in JS and pass history
-	w.bridge.Call("requestInput", js.ValueOf(hIface))
+		// Convert []string -> []interface{} for syscall/js.ValueOf
+		hIface := make([]interface{}, len(w.history))
+		for i, v := range w.history {
+			hIface[i] = v
+		}
+
+		// Request input from the page in JS and pass history
+		w.bridge.Call("requestInput", js.ValueOf(hIface))
+	}
 
 	// Wait for input to be sent via the inputChan
-	input := <-w.inputChan
+	var input string
+	if timeout == 0 {
+		input = <-w.inputChan
+	} else {
+		select {
+		case input = <-w.inputChan:
+		case <-time.After(timeout):
+			return "", false
+		}
+	}
 
 	// Store in history if non-blank and not duplicate of last entry
 	if len(strings.TrimSpace(input)) > 0 && (len(w.history) == 0 || w.history[len(w.history)-1] != input) {
@@ -58,47 +87,116 @@ func (w *WebExternal) ReadInput() string {
 		}
 	}
 
-	return input
+	return input, true
+}
+
+// ReadChar waits for a single key press on the page, giving up after the timeout if there is one
+func (w *WebExternal) ReadChar(timeout time.Duration) (rune, bool) {
+	if !w.charWaiting {
+		w.charWaiting = true
+		w.bridge.Call("requestChar")
+	}
+
+	if timeout == 0 {
+		return <-w.charChan, true
+	}
+
+	select {
+	case key := <-w.charChan:
+		return key, true
+	case <-time.After(timeout):
+		return 0, false
+	}
+}
+
+// OpenScript isn't supported in the browser, so input always comes from the keyboard
+func (w *WebExternal) OpenScript(name string) io.Reader {
+	return nil
+}
+
+// ShowStatus updates the status line element above the output
+func (w *WebExternal) ShowStatus(status zmachine.StatusLine) {
+	w.bridge.Call("showStatus", status.Location, status.Summary())
+}
+
+// ShowUpperWindow replaces the contents of the upper window element
+// Each line is passed as a list of [text, style] pairs
+func (w *WebExternal) ShowUpperWindow(lines [][]zmachine.TextRun) {
+	linesIface := make([]interface{}, len(lines))
+	for i, runs := range lines {
+		runsIface := make([]interface{}, len(runs))
+		for j, run := range runs {
+			runsIface[j] = []interface{}{run.Text, run.Style}
+		}
+		linesIface[i] = runsIface
+	}
+
+	w.bridge.Call("showUpperWindow", js.ValueOf(linesIface))
+}
+
+func (w *WebExternal) ClearLowerWindow() {
+	w.bridge.Call("clearScreen")
+}
+
+func (w *WebExternal) SetColour(fg int, bg int) {
+	w.bridge.Call("setColour", fg, bg)
+}
+
+func (w *WebExternal) SetTextStyle(style int) {
+	w.bridge.Call("setTextStyle", style)
+}
+
+// Capabilities tells the machine what the browser frontend can do
+func (w *WebExternal) Capabilities() zmachine.Capabilities {
+	return zmachine.Capabilities{
+		StatusLine:  true,
+		SplitScreen: true,
+		Styles:      true,
+		FixedPitch:  true,
+		TimedInput:  true,
+		Colours:     true,
+		Sound:       true,
+	}
+}
+
+// PlaySound hands the audio data over to the browser to play
+func (w *WebExternal) PlaySound(sound zmachine.Sound) {
+	data := js.Global().Get("Uint8Array").New(len(sound.Data))
+	js.CopyBytesToJS(data, sound.Data)
+	w.bridge.Call("playSound", sound.Number, sound.Format, data, sound.Volume, sound.Repeats)
+}
+
+func (w *WebExternal) StopSound() {
+	w.bridge.Call("stopSound")
 }
 
-func (w *WebExternal) PlaySound(soundID uint16, effect uint16, volume uint16) {
-	w.bridge.Call("playSound", soundID, effect, volume)
+func (w *WebExternal) Bleep(high bool) {
+	w.bridge.Call("bleep", high)
 }
 
-func (w *WebExternal) Load(name string, machine *zmachine.Machine) bool {
-	// Access localStorage to get saved game data via js
+func (w *WebExternal) Load(name string) []byte {
+	// Access localStorage to get saved game data via js, it's stored as base64
 	savedData := js.Global().Get("localStorage").Call("getItem", name+"_save")
 	if savedData.IsNull() || savedData.IsUndefined() || savedData.String() == "" {
 		w.info("No saved game file found: " + name + "_save\n")
-		return false
+		return nil
 	}
 
-	var saveData zmachine.SaveState
-
-	err := json.Unmarshal([]byte(savedData.String()), &saveData)
+	data, err := base64.StdEncoding.DecodeString(savedData.String())
 	if err != nil {
 		w.info("Error decoding saved game data: " + err.Error() + "\n")
-		return false
+		return nil
 	}
 
 	w.info("Game loaded from browser storage: " + name + "_save\n")
-
-	// Restore machine state
-	machine.ReplaceState(&saveData)
-	return true
+	return data
 }
 
-func (w *WebExternal) Save(state *zmachine.SaveState) bool {
-	// Snapshot all of the machine state to localStorage
-	data, err := json.Marshal(state)
-	if err != nil {
-		fmt.Printf("Error encoding save data: %v\n", err)
-		return false
-	}
-
-	js.Global().Get("localStorage").Call("setItem", state.Name+"_save", string(data))
+func (w *WebExternal) Save(name string, data []byte) bool {
+	// Quetzal data is binary, so store it in localStorage as base64
+	js.Global().Get("localStorage").Call("setItem", name+"_save", base64.StdEncoding.EncodeToString(data))
 
-	w.info("Game saved to DF0:/saves/" + state.Name + "_save\n")
+	w.info("Game saved to DF0:/saves/" + name + "_save\n")
 	return true
 }
 
@@ -110,6 +208,55 @@ func (w *WebExternal) info(format string, a ...interface{}) {
 	w.TextOut(fmt.Sprintf("+++ "+format, a...))
 }
 
+// Unblocks a pending ReadInput with an empty line, e.g. after a restore from the menu
+func (w *WebExternal) cancelInput() {
+	if !w.inputWaiting {
+		return
+	}
+
+	w.inputChan <- ""
+	w.inputWaiting = false
+}
+
+// AbandonInput stops the page taking input for a timed read the game has given up on
+func (w *WebExternal) AbandonInput() {
+	if !w.inputWaiting {
+		return
+	}
+
+	w.inputWaiting = false
+	w.bridge.Call("abandonInput")
+
+	// A line might have been sent just as the read was given up
+	select {
+	case <-w.inputChan:
+	default:
+	}
+}
+
+// Gives a pending ReadInput a line as if it had been typed, false if no input is waiting
+func (w *WebExternal) sendInput(line string) bool {
+	if !w.inputWaiting {
+		return false
+	}
+
+	w.TextOut(line + "\n")
+	w.inputChan <- line
+	w.inputWaiting = false
+	return true
+}
+
+// Called from JS with the key code of a key pressed while waiting in ReadChar
+func (w *WebExternal) receiveChar(this js.Value, args []js.Value) interface{} {
+	if !w.charWaiting {
+		return nil
+	}
+
+	w.charChan <- rune(args[0].Int())
+	w.charWaiting = false
+	return nil
+}
+
 func (w *WebExternal) receiveInput(this js.Value, args []js.Value) interface{} {
 	if !w.inputWaiting {
 		return nil
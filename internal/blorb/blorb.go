@@ -0,0 +1,203 @@
+// ============================================================================
+// GoZm - Z-Machine interpreter written in Go
+// Copyright (c) 2025 - Ben Coleman
+// Blorb, the IFF container that packages a story with its pictures & sounds
+// ============================================================================
+
+package blorb
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Resource usages, as given in the resource index
+// See: https://www.eblong.com/zarf/blorb/blorb.html#s2
+const (
+	USAGE_EXEC  = "Exec"
+	USAGE_PICT  = "Pict"
+	USAGE_SOUND = "Snd "
+)
+
+// Resource is a single story, picture or sound held in the file
+type Resource struct {
+	Usage  string // One of the USAGE_* values
+	Number uint32 // Resource number, as used by the game
+	Type   string // Chunk type, e.g. ZCOD, PNG or OGGV, or the form type for an IFF form like AIFF
+	Data   []byte // Chunk data without the chunk header, apart from IFF forms which are kept whole
+}
+
+// Blorb is a loaded Blorb file
+type Blorb struct {
+	Resources    []Resource        // Everything in the resource index
+	Metadata     string            // iFiction XML from the IFmd chunk, empty if there isn't one
+	Frontispiece int               // Picture number of the cover image from the Fspc chunk, -1 if none
+	Loops        map[uint32]uint32 // Repeats for v3 sounds from the Loop chunk, where 0 means forever
+}
+
+// IsBlorb checks if data looks like a Blorb file, rather than a raw story
+func IsBlorb(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "FORM" && string(data[8:12]) == "IFRS"
+}
+
+// Load parses a Blorb file, finding all the resources in its index
+func Load(data []byte) (*Blorb, error) {
+	if !IsBlorb(data) {
+		return nil, errors.New("not a Blorb file")
+	}
+
+	b := &Blorb{Frontispiece: -1}
+
+	formLen := int(binary.BigEndian.Uint32(data[4:8])) + 8
+	if formLen > len(data) {
+		return nil, errors.New("blorb file is truncated")
+	}
+
+	// First pass to index the chunks by offset, the resource index refers to them that way
+	// Chunks are padded to an even length
+	type chunk struct {
+		id   string
+		data []byte
+	}
+	chunks := map[uint32]chunk{}
+	var index []byte
+
+	for pos := 12; pos+8 <= formLen; {
+		id := string(data[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		if pos+8+size > formLen {
+			return nil, fmt.Errorf("chunk %q at %d is truncated", id, pos)
+		}
+		body := data[pos+8 : pos+8+size]
+
+		switch id {
+		case "RIdx":
+			index = body
+		case "IFmd":
+			b.Metadata = string(body)
+		case "Loop":
+			// Pairs of sound number and repeat count
+			b.Loops = map[uint32]uint32{}
+			for i := 0; i+8 <= size; i += 8 {
+				b.Loops[binary.BigEndian.Uint32(body[i:])] = binary.BigEndian.Uint32(body[i+4:])
+			}
+		case "Fspc":
+			if size >= 4 {
+				b.Frontispiece = int(binary.BigEndian.Uint32(body))
+			}
+		case "FORM":
+			// AIFF sounds are whole IFF forms, so they are kept with their header
+			body = data[pos : pos+8+size]
+			if size >= 4 {
+				id = string(body[8:12])
+			}
+		}
+
+		chunks[uint32(pos)] = chunk{id: id, data: body}
+		pos += 8 + size + size%2
+	}
+
+	if index == nil {
+		return nil, errors.New("blorb file has no resource index")
+	}
+
+	// The index is a count followed by 12 byte entries of usage, number and chunk offset
+	if len(index) < 4 {
+		return nil, errors.New("blorb resource index is truncated")
+	}
+	count := int(binary.BigEndian.Uint32(index))
+	if len(index) < 4+count*12 {
+		return nil, errors.New("blorb resource index is truncated")
+	}
+
+	for i := 0; i < count; i++ {
+		entry := index[4+i*12:]
+		start := binary.BigEndian.Uint32(entry[8:12])
+		c, ok := chunks[start]
+		if !ok {
+			return nil, fmt.Errorf("resource %d points to missing chunk at %d", i, start)
+		}
+
+		b.Resources = append(b.Resources, Resource{
+			Usage:  string(entry[0:4]),
+			Number: binary.BigEndian.Uint32(entry[4:8]),
+			Type:   c.id,
+			Data:   c.data,
+		})
+	}
+
+	return b, nil
+}
+
+// Find gets a resource by its usage & number, nil if there's no such resource
+func (b *Blorb) Find(usage string, number uint32) *Resource {
+	for i := range b.Resources {
+		if b.Resources[i].Usage == usage && b.Resources[i].Number == number {
+			return &b.Resources[i]
+		}
+	}
+
+	return nil
+}
+
+// Story gets the Z-code story to run, the Exec resource held in a ZCOD chunk
+func (b *Blorb) Story() ([]byte, error) {
+	for _, r := range b.Resources {
+		if r.Usage != USAGE_EXEC {
+			continue
+		}
+
+		if r.Type != "ZCOD" {
+			return nil, fmt.Errorf("blorb holds a %s story, not Z-code", strings.TrimSpace(r.Type))
+		}
+		return r.Data, nil
+	}
+
+	return nil, errors.New("blorb file holds no story")
+}
+
+// Cover gets the frontispiece picture, nil if there isn't one
+func (b *Blorb) Cover() *Resource {
+	if b.Frontispiece < 0 {
+		return nil
+	}
+
+	return b.Find(USAGE_PICT, uint32(b.Frontispiece))
+}
+
+// Title gets the story's title from the iFiction metadata, empty if there isn't one
+// See: https://babel.ifarchive.org/babel_rev11.html#the-bibliographic-section
+func (b *Blorb) Title() string {
+	var ifiction struct {
+		Title string `xml:"story>bibliographic>title"`
+	}
+
+	if err := xml.Unmarshal([]byte(b.Metadata), &ifiction); err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(ifiction.Title)
+}
+
+// Unwrap gets the story from data that may be a Blorb file or a raw story
+// The Blorb is returned too if there was one, so its resources can be used
+func Unwrap(data []byte) ([]byte, *Blorb, error) {
+	if !IsBlorb(data) {
+		return data, nil, nil
+	}
+
+	b, err := Load(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	story, err := b.Story()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return story, b, nil
+}
@@ -0,0 +1,105 @@
+// ============================================================================
+// GoZm - Z-Machine interpreter written in Go
+// Copyright (c) 2025 - Ben Coleman
+// Tests for loading Blorb files, which are built here chunk by chunk
+// ============================================================================
+
+package blorb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Builds an IFF chunk, padded to an even length
+func chunk(id string, data []byte) []byte {
+	out := append([]byte(id), binary.BigEndian.AppendUint32(nil, uint32(len(data)))...)
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// entry is a resource in the index of a built Blorb file
+type entry struct {
+	usage  string
+	number uint32
+}
+
+// Builds a Blorb file with a resource for each chunk, in the order given
+// The offsets in the index are worked out from the chunks that follow it
+func build(entries []entry, chunks ...[]byte) []byte {
+	index := binary.BigEndian.AppendUint32(nil, uint32(len(entries)))
+	pos := 12 + 8 + 4 + len(entries)*12 // Form header, then the RIdx chunk
+	for i, e := range entries {
+		index = append(index, e.usage...)
+		index = binary.BigEndian.AppendUint32(index, e.number)
+		index = binary.BigEndian.AppendUint32(index, uint32(pos))
+		pos += len(chunks[i])
+	}
+
+	return form(append(chunk("RIdx", index), bytes.Join(chunks, nil)...))
+}
+
+// Wraps chunks up as a Blorb file
+func form(body []byte) []byte {
+	return chunk("FORM", append([]byte("IFRS"), body...))
+}
+
+func TestLoad(t *testing.T) {
+	story := []byte{3, 0, 0, 1, 2}         // Odd length, so the chunk is padded
+	pict := []byte{0x89, 'P', 'N', 'G', 1} // Follows the padding
+	data := build([]entry{{USAGE_EXEC, 0}, {USAGE_PICT, 1}}, chunk("ZCOD", story), chunk("PNG ", pict))
+
+	b, err := Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := b.Story()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, story) {
+		t.Errorf("story is % X, want % X", got, story)
+	}
+
+	r := b.Find(USAGE_PICT, 1)
+	if r == nil {
+		t.Fatal("picture 1 not found")
+	}
+	if r.Type != "PNG " || !bytes.Equal(r.Data, pict) {
+		t.Errorf("picture is %q % X, want PNG % X", r.Type, r.Data, pict)
+	}
+}
+
+func TestLoadTruncatedIndex(t *testing.T) {
+	tests := map[string][]byte{
+		"no count":          form(chunk("RIdx", []byte{0, 0})),
+		"missing entries":   form(chunk("RIdx", []byte{0, 0, 0, 2, 'E', 'x', 'e', 'c'})),
+		"chunk past end":    form(chunk("RIdx", []byte{0, 0, 0, 0}))[:20],
+		"no resource index": form(chunk("ZCOD", []byte{3})),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Load(data); err == nil {
+				t.Error("loaded without an error")
+			}
+		})
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	raw := []byte{5, 0, 0, 0}
+	story, b, err := Unwrap(raw)
+	if err != nil || b != nil || !bytes.Equal(story, raw) {
+		t.Errorf("raw story wasn't passed through: % X, %v, %v", story, b, err)
+	}
+
+	if _, _, err := Unwrap(form(chunk("RIdx", []byte{0}))); err == nil {
+		t.Error("truncated Blorb unwrapped without an error")
+	}
+}
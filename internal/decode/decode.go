@@ -6,8 +6,59 @@
 
 package decode
 
+// Decoder holds what's needed to decode & encode text for a particular game, which depends on the
+// version, the alphabets, the abbreviations and the table mapping ZSCII codes 155+ to Unicode
+type Decoder struct {
+	Version   byte
+	Alphabets Alphabets
+	Abbr      []string // Abbreviations, already decoded
+	Unicode   []rune   // ZSCII codes from 155 upwards, see UnicodeTable
+}
+
+// NewDecoder sets up a decoder for a story, with the alphabets, abbreviations and Unicode
+// table that its header points to
+func NewDecoder(mem []byte) Decoder {
+	d := Decoder{
+		Version:   mem[0x00],
+		Alphabets: DefaultAlphabets,
+		Unicode:   UnicodeTable(mem),
+	}
+
+	// Version 1 has its own A2, and from v5 there can be a custom alphabet table
+	if d.Version == 1 {
+		d.Alphabets = V1Alphabets
+	}
+	if d.Version >= 5 {
+		if tableAddr := GetWord(mem, 0x34); tableAddr != 0 {
+			d.Alphabets = d.AlphabetTable(mem, tableAddr)
+		}
+	}
+
+	// Version 1 has no abbreviations, and version 2 only has 32
+	numAbbr := uint16(96)
+	switch d.Version {
+	case 1:
+		numAbbr = 0
+	case 2:
+		numAbbr = 32
+	}
+
+	// Abbreviation table contains word addresses, need to multiply by 2
+	// See: https://zspec.jaredreisinger.com/01-memory-map#1_2_2
+	abbrAddr := GetWord(mem, 0x18)
+	d.Abbr = make([]string, numAbbr)
+	for i := uint16(0); i < numAbbr; i++ {
+		d.Abbr[i], _ = d.StringAt(mem, uint32(GetWord(mem, abbrAddr+i*2))*2)
+	}
+
+	return d
+}
+
+// Alphabets holds the three alphabets, A0, A1 & A2, of 26 characters each used for z-chars 6 to 31
+type Alphabets [3][]rune
+
 // Lookups used for decoding z-chars, see: https://zspec.jaredreisinger.com/03-text#3_5_3
-var alphabets = [][]rune{
+var DefaultAlphabets = Alphabets{
 	{
 		'a', 'b', 'c', 'd', 'e', 'f', 'g',
 		'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o',
@@ -29,6 +80,37 @@ var alphabets = [][]rune{
 	},
 }
 
+// Version 1 has a different A2, without newline and with '<' instead
+// See: https://zspec.jaredreisinger.com/03-text#3_5_4
+var V1Alphabets = Alphabets{
+	DefaultAlphabets[0],
+	DefaultAlphabets[1],
+	{
+		// Note value 0 would normally never be output, it means escape
+		' ', '0', '1', '2', '3', '4', '5', '6',
+		'7', '8', '9', '.', ',', '!', '?', '_',
+		'#', '\'', '"', '/', '\\', '<', '-', ':',
+		'(', ')',
+	},
+}
+
+// AlphabetTable reads a custom alphabet table of 78 ZSCII codes, as a v5+ game can supply
+// The first two characters of A2 keep their special meanings whatever the table says
+// See: https://zspec.jaredreisinger.com/03-text#3_5_5
+func (d *Decoder) AlphabetTable(b []byte, offset uint16) Alphabets {
+	var a Alphabets
+	for i := range a {
+		a[i] = make([]rune, 26)
+		for j := range a[i] {
+			a[i][j] = d.zsciiRune(uint16(b[offset+uint16(i*26+j)]))
+		}
+	}
+
+	a[2][0] = ' '
+	a[2][1] = '\n'
+	return a
+}
+
 // GetWord reads a 2-byte big-endian integer from the given byte slice at the specified offset.
 func GetWord(b []byte, offset uint16) uint16 {
 	return uint16(b[offset])<<8 | uint16(b[offset+1])
@@ -49,15 +131,62 @@ func SetWord(b []byte, offset uint16, value uint16) {
 	b[offset+1] = byte(value & 0xFF)
 }
 
-// Packed address in v3 is multiplied by 2 https://zspec.jaredreisinger.com/01-memory-map#1_2_3
-func PackedAddress(addr uint16) uint32 {
-	return uint32(addr) * 2
+// SetWord32 writes a 2-byte big-endian integer at a 32-bit offset, for memory above 64K
+func SetWord32(b []byte, offset uint32, value uint16) {
+	b[offset] = byte((value >> 8) & 0xFF)
+	b[offset+1] = byte(value & 0xFF)
+}
+
+// Packed addresses are multiplied by 2 in v1-3, by 4 in v4-7 and by 8 in v8
+// In v6 & v7 the routine and string offsets from the header also need adding, see Machine
+// See: https://zspec.jaredreisinger.com/01-memory-map#1_2_3
+func PackedAddress(addr uint16, version byte) uint32 {
+	switch {
+	case version <= 3:
+		return uint32(addr) * 2
+	case version <= 7:
+		return uint32(addr) * 4
+	default:
+		return uint32(addr) * 8
+	}
+}
+
+// FileLength gets the length of the story file in bytes from the header, where it is
+// stored divided by 2 in v1-3, by 4 in v4 & v5 and by 8 from v6
+// See: https://zspec.jaredreisinger.com/11-header#11_1_6
+func FileLength(header []byte) uint32 {
+	length := uint32(GetWord(header, 0x1A))
+	switch version := header[0x00]; {
+	case version <= 3:
+		return length * 2
+	case version <= 5:
+		return length * 4
+	default:
+		return length * 8
+	}
+}
+
+// StringAt decodes the string stored at an address, which ends with the word that has its top bit set
+// Returns the string and the number of words it took up
+func (d *Decoder) StringAt(mem []byte, addr uint32) (string, int) {
+	words := []uint16{}
+	for a := addr; int(a)+1 < len(mem); a += 2 {
+		word := GetWord32(mem, a)
+		words = append(words, word)
+
+		if word&0x8000 != 0 {
+			break
+		}
+	}
+
+	return d.String(words), len(words)
 }
 
 // String decodes a Z-machine encoded string from the given slice of 16-bit words
-// each containing three 5-bit Z-characters. It's weird AF
+// each containing three 5-bit Z-characters. It's weird AF, and weirder still in v1 & v2
 // https://zspec.jaredreisinger.com/03-text
-func String(words []uint16, abbr []string) string {
+func (d *Decoder) String(words []uint16) string {
+	alphabets, version := d.Alphabets, d.Version
 	result := ""
 	zchars := make([]byte, len(words)*3)
 
@@ -70,62 +199,152 @@ func String(words []uint16, abbr []string) string {
 	}
 
 	// Decode Z-chars into a string
+	// In v1 & v2 the alphabet can be shift locked, later it's always A0 between characters
 	alphabet := 0
+	lockAlphabet := 0
 	for i := 0; i < len(zchars); i++ {
 		zchar := zchars[i]
 
-		switch zchar {
-		case 0:
+		switch {
+		case zchar == 0:
 			result += " " // Z-char 0 is space
+			alphabet = lockAlphabet
+			continue
+
+		case zchar == 1 && version == 1:
+			result += "\n" // Only version 1 has a Z-char for newline
+			alphabet = lockAlphabet
 			continue
-		case 1, 2, 3:
+
+		case zchar >= 2 && zchar <= 5 && version <= 2:
+			// In v1 & v2, 2 & 3 shift up or down an alphabet for the next character only, 4 & 5 shift lock
+			// See: https://zspec.jaredreisinger.com/03-text#3_2_2
+			shift := 1
+			if zchar == 3 || zchar == 5 {
+				shift = 2
+			}
+
+			alphabet = (lockAlphabet + shift) % 3
+			if zchar >= 4 {
+				lockAlphabet = alphabet
+			}
+			continue
+
+		case zchar <= 3:
 			// In Versions 3 and later, Z-characters 1, 2 and 3 represent abbreviations
+			// Version 2 only has the one bank of 32, given by Z-character 1
 			// See: https://zspec.jaredreisinger.com/03-text#3_3
 			if i < len(zchars)-1 {
 				abbrIndex := (int(zchar)-1)*32 + int(zchars[i+1])
-				if abbrIndex < len(abbr) {
-					result += abbr[abbrIndex]
+				if abbrIndex < len(d.Abbr) {
+					result += d.Abbr[abbrIndex]
 				}
 				i++ // Skip next zchar
-				alphabet = 0
+				alphabet = lockAlphabet
 				continue
 			}
-		case 4:
+
+		case zchar == 4:
 			alphabet = 1 // Switch to upper case
 			continue
-		case 5:
+
+		case zchar == 5:
 			alphabet = 2 // Switch to punctuation
 			continue
-		case 6:
+
+		case zchar == 6 && alphabet == 2:
 			// See https://zspec.jaredreisinger.com/03-text#3_4
-			if alphabet == 2 {
-				if i < len(zchars)-2 {
-					zc10 := (zchars[i+1] << 5) | zchars[i+2]
-					result += ZSCIIChar(zc10)
-					i += 2 // Skip next two zchars
-					alphabet = 0
-					continue
-				}
+			if i < len(zchars)-2 {
+				zc10 := uint16(zchars[i+1])<<5 | uint16(zchars[i+2])
+				result += d.ZSCIIChar(zc10)
+				i += 2 // Skip next two zchars
+				alphabet = lockAlphabet
+				continue
 			}
 
-			fallthrough
+			result += string(alphabets[alphabet][zchar-6])
+
 		default:
 			result += string(alphabets[alphabet][zchar-6])
 		}
 
-		alphabet = 0 // Reset to default alphabet after use, this is v3 behaviour
+		alphabet = lockAlphabet // Reset to the locked alphabet, which is always A0 from v3
 	}
 
 	return string(result)
 }
 
-func ZSCIIChar(zchar byte) string {
-	if zchar >= 32 && zchar <= 126 {
-		return string(rune(zchar))
+// Encode turns a word into the z-chars used by dictionary entries, which hold 6 z-chars in two words
+// up to v3 and 9 z-chars in three words after that, so longer words are cut short
+// See: https://zspec.jaredreisinger.com/13-dictionary#13_3
+func (d *Decoder) Encode(word string) []uint16 {
+	if d.Version > 3 {
+		return d.EncodeText(word, 9)
+	}
+	return d.EncodeText(word, 6)
+}
+
+// EncodeText turns text into exactly numZChars z-chars packed 3 to a word, as dictionary words are
+// Longer text is cut short and shorter text padded with 5s, the last word has its top bit set
+// See: https://zspec.jaredreisinger.com/03-text#3_7
+func (d *Decoder) EncodeText(text string, numZChars int) []uint16 {
+	zchars := make([]byte, 0, numZChars+3)
+	for _, r := range text {
+		if len(zchars) >= numZChars {
+			break
+		}
+
+		zchars = append(zchars, d.encodeChar(r)...)
+	}
+
+	for len(zchars) < numZChars {
+		zchars = append(zchars, 5)
+	}
+	zchars = zchars[:numZChars]
+
+	words := make([]uint16, numZChars/3)
+	for i := range words {
+		words[i] = uint16(zchars[i*3])<<10 | uint16(zchars[i*3+1])<<5 | uint16(zchars[i*3+2])
+	}
+	words[len(words)-1] |= 0x8000
+
+	return words
+}
+
+// Z-chars for a single character, a shift is needed for anything not in A0
+// Characters in no alphabet are given as a 10-bit ZSCII escape sequence
+func (d *Decoder) encodeChar(r rune) []byte {
+	if r == ' ' {
+		return []byte{0}
 	}
+	alphabets, version := d.Alphabets, d.Version
 
-	// Handle special ZSCII characters here if needed, but for now return 0
-	return string(rune(0))
+	// The single character shifts to A1 & A2 are 4 & 5, apart from in v1 & v2 where they're 2 & 3
+	shiftA1, shiftA2 := byte(4), byte(5)
+	if version <= 2 {
+		shiftA1, shiftA2 = 2, 3
+	}
+
+	for i, alphabet := range alphabets {
+		for j, c := range alphabet {
+			// The first A2 character is the escape, so it can't be matched
+			if c != r || (i == 2 && j == 0) {
+				continue
+			}
+
+			switch i {
+			case 0:
+				return []byte{byte(j + 6)}
+			case 1:
+				return []byte{shiftA1, byte(j + 6)}
+			default:
+				return []byte{shiftA2, byte(j + 6)}
+			}
+		}
+	}
+
+	zscii, _ := d.ZSCIICode(r)
+	return []byte{shiftA2, 6, byte(zscii >> 5), byte(zscii & 0x1F)}
 }
 
 // Convert 14-bit value to signed 16-bit: if bit 13 is set, it's negative
@@ -136,7 +355,7 @@ func Convert14BitToSigned(val uint16) int16 {
 	return int16(val)
 }
 
-// Version 3 property size & num decoding
+// Version 1-3 property size & num decoding
 func PropSizeNumber(sizeByte byte) (byte, byte) {
 	// In version 3, the size is encoded in the top 3 bits of the size byte
 	// Size = (top 3 bits >> 5) + 1
@@ -145,3 +364,25 @@ func PropSizeNumber(sizeByte byte) (byte, byte) {
 	propSize := (sizeByte>>5)&0x07 + 1 // size is stored as size-1
 	return propNum, propSize
 }
+
+// Version 4+ property size & num decoding, the size can take one or two bytes
+// Returns the property number, the data size and how many size bytes there were
+// See: https://zspec.jaredreisinger.com/12-objects#12_4_2
+func PropSizeNumberV4(first byte, second byte) (byte, byte, uint16) {
+	propNum := first & 0x3F
+
+	// Bit 7 set means a second size byte follows, holding the size in its bottom 6 bits
+	if first&0x80 != 0 {
+		propSize := second & 0x3F
+		if propSize == 0 {
+			propSize = 64 // A size of 0 is taken to mean 64
+		}
+		return propNum, propSize, 2
+	}
+
+	// Otherwise bit 6 picks between a size of 1 and 2
+	if first&0x40 != 0 {
+		return propNum, 2, 1
+	}
+	return propNum, 1, 1
+}
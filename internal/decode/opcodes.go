@@ -0,0 +1,183 @@
+// ============================================================================
+// GoZm - Z-Machine interpreter written in Go
+// Copyright (c) 2025 - Ben Coleman
+// Names of the opcodes in the Z-machine instruction set
+// ============================================================================
+
+package decode
+
+// OpcodeName gets the name of an instruction from its opcode byte, for extended instructions
+// this is the opcode number following 0xBE. Unknown opcodes give an empty string
+func OpcodeName(code byte, ext bool, version byte) string {
+	if ext {
+		return extOpcodeNames[code]
+	}
+
+	// 2OP instructions can also be given in VAR form, as 0xC0 to 0xDF
+	if code >= 0xC0 && code < 0xE0 {
+		code &= 0x1F
+	}
+
+	if version >= 5 && v5OpcodeNames[code] != "" {
+		return v5OpcodeNames[code]
+	}
+
+	return opcodeNames[code]
+}
+
+// opcodeNames maps opcode byte values to instruction names for Z-machine versions 1-5.
+// Where v5 changed the meaning of an opcode the new name is in v5OpcodeNames instead.
+// Operand-type variants of 1OP and 2OP instructions (small const, large const, variable)
+// are all mapped individually to the same mnemonic.
+var opcodeNames = map[byte]string{
+	// 0OP (short form with operand type = omitted) B0-BD, excluding extended (BE) and piracy (BF v5+)
+	0xB0: "rtrue",
+	0xB1: "rfalse",
+	0xB2: "print",
+	0xB3: "print_ret",
+	0xB4: "nop", // version 1 (ignored later)
+	0xB5: "save",
+	0xB6: "restore",
+	0xB7: "restart",
+	0xB8: "ret_popped",
+	0xB9: "pop",
+	0xBA: "quit",
+	0xBB: "new_line",
+	0xBC: "show_status", // v3 only, in v1 & v2 the status line is only drawn before input
+	0xBD: "verify",      // v3+
+	0xBF: "piracy",      // v5+
+
+	// 1OP (short form with one operand) Large const (80-8F), Small const (90-9F), Variable (A0-AF)
+	// not (15) is call_1n in v5+, see v5OpcodeNames
+	0x80: "jz", 0x90: "jz", 0xA0: "jz",
+	0x81: "get_sibling", 0x91: "get_sibling", 0xA1: "get_sibling",
+	0x82: "get_child", 0x92: "get_child", 0xA2: "get_child",
+	0x83: "get_parent", 0x93: "get_parent", 0xA3: "get_parent",
+	0x84: "get_prop_len", 0x94: "get_prop_len", 0xA4: "get_prop_len",
+	0x85: "inc", 0x95: "inc", 0xA5: "inc",
+	0x86: "dec", 0x96: "dec", 0xA6: "dec",
+	0x87: "print_addr", 0x97: "print_addr", 0xA7: "print_addr",
+	0x88: "call_1s", 0x98: "call_1s", 0xA8: "call_1s", // v4+
+	0x89: "remove_obj", 0x99: "remove_obj", 0xA9: "remove_obj",
+	0x8A: "print_obj", 0x9A: "print_obj", 0xAA: "print_obj",
+	0x8B: "ret", 0x9B: "ret", 0xAB: "ret",
+	0x8C: "jump", 0x9C: "jump", 0xAC: "jump",
+	0x8D: "print_paddr", 0x9D: "print_paddr", 0xAD: "print_paddr",
+	0x8E: "load", 0x9E: "load", 0xAE: "load",
+	// 0x8F/0x9F/0xAF: 'not' kept (present in early versions); later repurposed but still valid name here
+	0x8F: "not", 0x9F: "not", 0xAF: "not",
+
+	// 2OP (long form) operand-type variants: base (00-1F), +0x20, +0x40, +0x60
+	// Instruction numbers 1-24 (je..mod) are valid in v1-3, 25 (call_2s) is v4+, 26-28 (call_2n, set_colour, throw) are v5+
+	// je (1)
+	0x01: "je", 0x21: "je", 0x41: "je", 0x61: "je",
+	// jl (2)
+	0x02: "jl", 0x22: "jl", 0x42: "jl", 0x62: "jl",
+	// jg (3)
+	0x03: "jg", 0x23: "jg", 0x43: "jg", 0x63: "jg",
+	// dec_chk (4)
+	0x04: "dec_chk", 0x24: "dec_chk", 0x44: "dec_chk", 0x64: "dec_chk",
+	// inc_chk (5)
+	0x05: "inc_chk", 0x25: "inc_chk", 0x45: "inc_chk", 0x65: "inc_chk",
+	// jin (6)
+	0x06: "jin", 0x26: "jin", 0x46: "jin", 0x66: "jin",
+	// test (bitmap flags) (7)
+	0x07: "test", 0x27: "test", 0x47: "test", 0x67: "test",
+	// or (8)
+	0x08: "or", 0x28: "or", 0x48: "or", 0x68: "or",
+	// and (9)
+	0x09: "and", 0x29: "and", 0x49: "and", 0x69: "and",
+	// test_attr (10)
+	0x0A: "test_attr", 0x2A: "test_attr", 0x4A: "test_attr", 0x6A: "test_attr",
+	// set_attr (11)
+	0x0B: "set_attr", 0x2B: "set_attr", 0x4B: "set_attr", 0x6B: "set_attr",
+	// clear_attr (12)
+	0x0C: "clear_attr", 0x2C: "clear_attr", 0x4C: "clear_attr", 0x6C: "clear_attr",
+	// store (13)
+	0x0D: "store", 0x2D: "store", 0x4D: "store", 0x6D: "store",
+	// insert_obj (14)
+	0x0E: "insert_obj", 0x2E: "insert_obj", 0x4E: "insert_obj", 0x6E: "insert_obj",
+	// loadw (15)
+	0x0F: "loadw", 0x2F: "loadw", 0x4F: "loadw", 0x6F: "loadw",
+	// loadb (16)
+	0x10: "loadb", 0x30: "loadb", 0x50: "loadb", 0x70: "loadb",
+	// get_prop (17)
+	0x11: "get_prop", 0x31: "get_prop", 0x51: "get_prop", 0x71: "get_prop",
+	// get_prop_addr (18)
+	0x12: "get_prop_addr", 0x32: "get_prop_addr", 0x52: "get_prop_addr", 0x72: "get_prop_addr",
+	// get_next_prop (19)
+	0x13: "get_next_prop", 0x33: "get_next_prop", 0x53: "get_next_prop", 0x73: "get_next_prop",
+	// add (20)
+	0x14: "add", 0x34: "add", 0x54: "add", 0x74: "add",
+	// sub (21)
+	0x15: "sub", 0x35: "sub", 0x55: "sub", 0x75: "sub",
+	// mul (22)
+	0x16: "mul", 0x36: "mul", 0x56: "mul", 0x76: "mul",
+	// div (23)
+	0x17: "div", 0x37: "div", 0x57: "div", 0x77: "div",
+	// mod (24)
+	0x18: "mod", 0x38: "mod", 0x58: "mod", 0x78: "mod",
+	// call_2s (25) v4+
+	0x19: "call_2s", 0x39: "call_2s", 0x59: "call_2s", 0x79: "call_2s",
+	// call_2n (26) v5+
+	0x1A: "call_2n", 0x3A: "call_2n", 0x5A: "call_2n", 0x7A: "call_2n",
+	// set_colour (27) v5+
+	0x1B: "set_colour", 0x3B: "set_colour", 0x5B: "set_colour", 0x7B: "set_colour",
+	// throw (28) v5+
+	0x1C: "throw", 0x3C: "throw", 0x5C: "throw", 0x7C: "throw",
+
+	// VAR form (E0-FF)
+	0xE0: "call", // call with up to 3 args returning result, call_vs in v4+
+	0xE1: "storew",
+	0xE2: "storeb",
+	0xE3: "put_prop",
+	0xE4: "sread",
+	0xE5: "print_char",
+	0xE6: "print_num",
+	0xE7: "random",
+	0xE8: "push",
+	0xE9: "pull",
+	0xEA: "split_window", // v3+
+	0xEB: "set_window",   // v3+
+	0xEC: "call_vs2",     // v4+
+	0xED: "erase_window",
+	0xEE: "erase_line",
+	0xEF: "set_cursor",
+	0xF0: "get_cursor",
+	0xF1: "set_text_style",
+	0xF2: "buffer_mode",
+	0xF3: "output_stream", // v3+
+	0xF4: "input_stream",  // v3+
+	0xF5: "sound_effect",  // v3+
+	0xF6: "read_char",
+	0xF7: "scan_table",
+	0xF8: "not", // v5+
+	0xF9: "call_vn",
+	0xFA: "call_vn2",
+	0xFB: "tokenise",
+	0xFC: "encode_text",
+	0xFD: "copy_table",
+	0xFE: "print_table",
+	0xFF: "check_arg_count",
+}
+
+// v5OpcodeNames are the opcodes which v5 gave a new meaning
+var v5OpcodeNames = map[byte]string{
+	0x8F: "call_1n", 0x9F: "call_1n", 0xAF: "call_1n",
+	0xB9: "catch",
+	0xE0: "call_vs",
+	0xE4: "aread",
+}
+
+// extOpcodeNames maps the opcode numbers of the v5 extended instructions, which follow a 0xBE byte
+var extOpcodeNames = map[byte]string{
+	0x00: "save",
+	0x01: "restore",
+	0x02: "log_shift",
+	0x03: "art_shift",
+	0x04: "set_font",
+	0x09: "save_undo",
+	0x0A: "restore_undo",
+	0x0B: "print_unicode",
+	0x0C: "check_unicode",
+}
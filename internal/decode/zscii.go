@@ -0,0 +1,110 @@
+// ============================================================================
+// GoZm - Z-Machine interpreter written in Go
+// Copyright (c) 2025 - Ben Coleman
+// ZSCII, the Z-machine character set, and mapping it to and from Unicode
+// ============================================================================
+
+package decode
+
+// DefaultUnicode maps ZSCII codes 155 to 223 to the accented & other characters used by
+// European languages, unless a game supplies its own table
+// See: https://zspec.jaredreisinger.com/03-text#3_8_7
+var DefaultUnicode = []rune{
+	'ä', 'ö', 'ü', 'Ä', 'Ö', 'Ü', 'ß', '»', '«', 'ë', 'ï', 'ÿ', 'Ë', 'Ï', 'á', 'é',
+	'í', 'ó', 'ú', 'ý', 'Á', 'É', 'Í', 'Ó', 'Ú', 'Ý', 'à', 'è', 'ì', 'ò', 'ù', 'À',
+	'È', 'Ì', 'Ò', 'Ù', 'â', 'ê', 'î', 'ô', 'û', 'Â', 'Ê', 'Î', 'Ô', 'Û', 'å', 'Å',
+	'ø', 'Ø', 'ã', 'ñ', 'õ', 'Ã', 'Ñ', 'Õ', 'æ', 'Æ', 'ç', 'Ç', 'þ', 'ð', 'Þ', 'Ð',
+	'£', 'œ', 'Œ', '¡', '¿',
+}
+
+// First ZSCII code of the extra characters
+const zsciiExtraStart = 155
+
+// UnicodeTable gets the table of extra characters for a story, from v5 a game can give its own
+// in the header extension table, otherwise the default table is used
+// See: https://zspec.jaredreisinger.com/03-text#3_8_5_2
+func UnicodeTable(mem []byte) []rune {
+	if mem[0x00] < 5 {
+		return DefaultUnicode
+	}
+
+	// Word 3 of the header extension table holds the address of the Unicode table
+	extAddr := GetWord(mem, 0x36)
+	if extAddr == 0 || GetWord(mem, extAddr) < 3 {
+		return DefaultUnicode
+	}
+
+	tableAddr := GetWord(mem, extAddr+6)
+	if tableAddr == 0 {
+		return DefaultUnicode
+	}
+
+	// The table is a count byte, then that many words of Unicode characters
+	count := uint16(mem[tableAddr])
+	table := make([]rune, count)
+	for i := uint16(0); i < count; i++ {
+		table[i] = rune(GetWord(mem, tableAddr+1+i*2))
+	}
+
+	return table
+}
+
+// ZSCIIChar turns a ZSCII code into text for output, codes with no meaning for output
+// give a question mark, and zero gives nothing at all
+// See: https://zspec.jaredreisinger.com/03-text#3_8
+func (d *Decoder) ZSCIIChar(code uint16) string {
+	switch {
+	case code == 0:
+		return ""
+	case code == 9 && d.Version == 6:
+		return "\t"
+	case code == 11 && d.Version == 6:
+		return " " // Sentence space
+	case code == 13:
+		return "\n"
+	}
+
+	return string(d.zsciiRune(code))
+}
+
+// Single character for a ZSCII code, or a question mark if it can't be shown
+func (d *Decoder) zsciiRune(code uint16) rune {
+	switch {
+	case code >= 32 && code <= 126:
+		return rune(code)
+	case code == 13:
+		return '\n'
+	case code >= zsciiExtraStart && int(code-zsciiExtraStart) < len(d.Unicode):
+		return d.Unicode[code-zsciiExtraStart]
+	}
+
+	return '?'
+}
+
+// ZSCIICode turns a character of input into ZSCII, returning false if there is no code for it
+func (d *Decoder) ZSCIICode(r rune) (uint16, bool) {
+	switch {
+	case r >= 32 && r <= 126:
+		return uint16(r), true
+	case r == '\n' || r == '\r':
+		return 13, true
+	}
+
+	for i, c := range d.Unicode {
+		if c == r {
+			return uint16(zsciiExtraStart + i), true
+		}
+	}
+
+	return '?', false
+}
+
+// ZSCIIString turns a run of ZSCII codes, as held in a text buffer, into text
+func (d *Decoder) ZSCIIString(codes []byte) string {
+	result := ""
+	for _, code := range codes {
+		result += d.ZSCIIChar(uint16(code))
+	}
+
+	return result
+}
@@ -0,0 +1,476 @@
+// ============================================================================
+// GoZm - Z-Machine interpreter written in Go
+// Copyright (c) 2025 - Ben Coleman
+// Disassembler, turning the Z-code in a story back into readable instructions
+// ============================================================================
+
+package disasm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/benc-uk/gozm/internal/decode"
+)
+
+// Types of operand, as given by the opcode or the operand types byte
+// See: https://zspec.jaredreisinger.com/04-instructions#4_2
+const (
+	OPTYPE_LARGE_CONST = 0x00
+	OPTYPE_SMALL_CONST = 0x01
+	OPTYPE_VARIABLE    = 0x02
+	OPTYPE_OMITTED     = 0x03
+)
+
+const MAX_LOCALS = 15
+
+// Operand is an operand as it's held in the story, rather than the value it has when run
+type Operand struct {
+	Type  byte   // One of the OPTYPE_* values
+	Value uint16 // The constant, or the number of the variable
+}
+
+// Branch is where an instruction goes if its condition matches OnTrue
+type Branch struct {
+	OnTrue bool
+	Return int    // 0 or 1 when the branch returns false or true instead, otherwise -1
+	Target uint32 // Address branched to, when it's not a return
+}
+
+// Instruction is a single decoded instruction
+type Instruction struct {
+	Addr     uint32
+	Bytes    []byte // The whole instruction, including any store, branch and inline text
+	Name     string
+	Ext      bool // Extended form, v5+ only
+	Operands []Operand
+	Store    int     // Variable the result is stored in, -1 if it doesn't store
+	Branch   *Branch // Nil if it doesn't branch
+	Target   uint32  // Routine called or address jumped to, when it's a constant
+	Text     string  // Inline text for print & print_ret, or the string print_paddr prints
+}
+
+// Routine is a routine found by following calls from the start of the game
+type Routine struct {
+	Addr         uint32
+	Main         bool     // Where the game starts, which has no routine header before v6
+	Locals       []uint16 // Initial values of the locals, always 0 from v5
+	Instructions []*Instruction
+	CalledFrom   []uint32 // Routines that call this one
+	Err          error    // Why the routine couldn't be fully disassembled, if it couldn't
+}
+
+// Disassembler decodes the Z-code in a story file
+type Disassembler struct {
+	mem        []byte
+	version    byte
+	text       decode.Decoder
+	initialPC  uint32
+	routineOff uint32
+	stringOff  uint32
+}
+
+// New creates a disassembler for a story
+func New(story []byte) (*Disassembler, error) {
+	if len(story) < 64 || story[0x00] < 1 || story[0x00] > 8 {
+		return nil, errors.New("not a Z-machine story file")
+	}
+
+	return &Disassembler{
+		mem:        story,
+		version:    story[0x00],
+		text:       decode.NewDecoder(story),
+		initialPC:  uint32(decode.GetWord(story, 0x06)),
+		routineOff: uint32(decode.GetWord(story, 0x28)) * 8,
+		stringOff:  uint32(decode.GetWord(story, 0x2A)) * 8,
+	}, nil
+}
+
+// Decode decodes the instruction at an address
+func (d *Disassembler) Decode(addr uint32) (inst *Instruction, err error) {
+	// Reading past the end of memory means the instruction is truncated, or isn't really code
+	defer func() {
+		if r := recover(); r != nil {
+			inst, err = nil, fmt.Errorf("instruction at %05X runs past the end of the story", addr)
+		}
+	}()
+
+	mem := d.mem
+	inst = &Instruction{Addr: addr, Store: -1}
+	code := mem[addr]
+	pos := addr + 1
+
+	// EXTENDED form is 0xBE then the opcode number, with operands as in VAR form
+	if code == 0xBE && d.version >= 5 {
+		inst.Ext = true
+		code = mem[pos]
+		pos++
+	}
+
+	inst.Name = decode.OpcodeName(code, inst.Ext, d.version)
+	if inst.Name == "" || (!inst.Ext && d.version < minVersions[inst.Name]) {
+		return nil, fmt.Errorf("unknown opcode %02X at %05X", code, addr)
+	}
+
+	switch {
+	case inst.Ext || code&0xC0 == 0xC0:
+		// VAR form has the operand types in the next byte, or two bytes for call_vs2 & call_vn2
+		types := uint16(mem[pos]) << 8
+		pos++
+		count := 4
+		if !inst.Ext && (code == 0xEC || code == 0xFA) {
+			types |= uint16(mem[pos])
+			pos++
+			count = 8
+		}
+
+		for i := 0; i < count; i++ {
+			opType := byte(types>>(14-i*2)) & 0x3
+			if opType == OPTYPE_OMITTED {
+				break
+			}
+			inst.Operands = append(inst.Operands, d.operand(opType, &pos))
+		}
+
+	case code&0xC0 == 0x80:
+		// SHORT form has the type of its single operand, if any, in bits 4 & 5
+		if opType := (code >> 4) & 0x3; opType != OPTYPE_OMITTED {
+			inst.Operands = append(inst.Operands, d.operand(opType, &pos))
+		}
+
+	default:
+		// LONG form always has two operands, bits 6 & 5 say if each is a variable or a small constant
+		inst.Operands = append(inst.Operands, d.operand((code>>6)&0x1+1, &pos))
+		inst.Operands = append(inst.Operands, d.operand((code>>5)&0x1+1, &pos))
+	}
+
+	store, branch := shape(inst.Name, inst.Ext, d.version)
+	if store {
+		inst.Store = int(mem[pos])
+		pos++
+	}
+
+	if branch {
+		inst.Branch, pos = d.branch(pos)
+	}
+
+	if textOps[inst.Name] && !inst.Ext {
+		var words int
+		inst.Text, words = d.text.StringAt(mem, pos)
+		pos += uint32(words * 2)
+	}
+
+	inst.Bytes = mem[addr:pos]
+
+	// Work out where constant calls & jumps go, and what constant strings say
+	if len(inst.Operands) > 0 && inst.Operands[0].Type != OPTYPE_VARIABLE {
+		val := inst.Operands[0].Value
+		switch {
+		case callOps[inst.Name] && val != 0:
+			inst.Target = d.unpackRoutine(val)
+		case inst.Name == "jump":
+			inst.Target = uint32(int32(pos) + int32(int16(val)) - 2)
+		case inst.Name == "print_paddr":
+			inst.Text, _ = d.text.StringAt(mem, d.unpackString(val))
+		}
+	}
+
+	return inst, nil
+}
+
+// Reads an operand of the given type, moving pos past it
+func (d *Disassembler) operand(opType byte, pos *uint32) Operand {
+	op := Operand{Type: opType}
+	if opType == OPTYPE_LARGE_CONST {
+		op.Value = decode.GetWord32(d.mem, *pos)
+		*pos += 2
+	} else {
+		op.Value = uint16(d.mem[*pos])
+		*pos++
+	}
+
+	return op
+}
+
+// Reads the branch data at pos, returning it and the address after it
+// See: https://zspec.jaredreisinger.com/04-instructions#4_7
+func (d *Disassembler) branch(pos uint32) (*Branch, uint32) {
+	info := d.mem[pos]
+	b := &Branch{OnTrue: info&0x80 != 0, Return: -1}
+
+	var offset int16
+	if info&0x40 != 0 {
+		offset = int16(info & 0x3F)
+		pos++
+	} else {
+		offset = decode.Convert14BitToSigned(uint16(info&0x3F)<<8 | uint16(d.mem[pos+1]))
+		pos += 2
+	}
+
+	if offset == 0 || offset == 1 {
+		b.Return = int(offset)
+	} else {
+		b.Target = uint32(int32(pos) + int32(offset) - 2)
+	}
+
+	return b, pos
+}
+
+// Unpacks a routine address, in v6 & v7 these are offset by a value from the header
+func (d *Disassembler) unpackRoutine(packed uint16) uint32 {
+	addr := decode.PackedAddress(packed, d.version)
+	if d.version == 6 || d.version == 7 {
+		addr += d.routineOff
+	}
+	return addr
+}
+
+// Unpacks a string address, in v6 & v7 these are offset by a value from the header
+func (d *Disassembler) unpackString(packed uint16) uint32 {
+	addr := decode.PackedAddress(packed, d.version)
+	if d.version == 6 || d.version == 7 {
+		addr += d.stringOff
+	}
+	return addr
+}
+
+// Routines finds all the routines reachable from the start of the game, by following calls
+// Each routine is read until it can't carry on, and no branch or jump goes any further
+// Routines only called indirectly, e.g. from properties, are found when they sit straight
+// after another routine, as compilers lay them out one after another
+func (d *Disassembler) Routines() []*Routine {
+	found := map[uint32]*Routine{}
+
+	// The game starts with a routine header in v6, but before that at an instruction
+	main := &Routine{Addr: d.initialPC, Main: true}
+	if d.version == 6 {
+		main.Addr = d.unpackRoutine(uint16(d.initialPC))
+	}
+	found[main.Addr] = main
+	queue := []*Routine{main}
+
+	for len(queue) > 0 {
+		r := queue[0]
+		queue = queue[1:]
+		if r.Instructions == nil && r.Err == nil {
+			d.disassemble(r)
+		}
+
+		// A routine following this one, at the next packed address, is likely to be real code
+		// If it doesn't disassemble cleanly it's probably data or strings, so it's dropped
+		if next := d.nextRoutine(r); next != 0 && found[next] == nil {
+			follower := &Routine{Addr: next}
+			if d.disassemble(follower); follower.Err == nil {
+				found[next] = follower
+				queue = append(queue, follower)
+			}
+		}
+
+		for _, inst := range r.Instructions {
+			if !callOps[inst.Name] || inst.Target == 0 || int(inst.Target) >= len(d.mem) {
+				continue
+			}
+
+			called, ok := found[inst.Target]
+			if !ok {
+				called = &Routine{Addr: inst.Target}
+				found[inst.Target] = called
+				queue = append(queue, called)
+			}
+
+			if len(called.CalledFrom) == 0 || called.CalledFrom[len(called.CalledFrom)-1] != r.Addr {
+				called.CalledFrom = append(called.CalledFrom, r.Addr)
+			}
+		}
+	}
+
+	routines := make([]*Routine, 0, len(found))
+	for _, r := range found {
+		routines = append(routines, r)
+	}
+	sort.Slice(routines, func(i, j int) bool { return routines[i].Addr < routines[j].Addr })
+
+	return routines
+}
+
+// Address just past the end of a routine, rounded up to where a packed address could point
+// Returns 0 if the routine is empty or runs off the end of the story
+func (d *Disassembler) nextRoutine(r *Routine) uint32 {
+	if len(r.Instructions) == 0 || r.Err != nil {
+		return 0
+	}
+
+	last := r.Instructions[len(r.Instructions)-1]
+	end := last.Addr + uint32(len(last.Bytes))
+	align := decode.PackedAddress(1, d.version)
+	end = (end + align - 1) / align * align
+
+	if int(end) >= len(d.mem) {
+		return 0
+	}
+	return end
+}
+
+// Reads the header & instructions of a routine
+// See: https://zspec.jaredreisinger.com/05-routines
+func (d *Disassembler) disassemble(r *Routine) {
+	pos := r.Addr
+	if !r.Main || d.version == 6 {
+		count := int(d.mem[pos])
+		if count > MAX_LOCALS {
+			r.Err = fmt.Errorf("routine header says it has %d locals", count)
+			return
+		}
+		pos++
+
+		// Before v5 the header holds the initial values of the locals
+		r.Locals = make([]uint16, count)
+		if d.version <= 4 {
+			for i := range r.Locals {
+				r.Locals[i] = decode.GetWord32(d.mem, pos)
+				pos += 2
+			}
+		}
+	}
+
+	// Keep going until a return or jump, unless a branch or jump goes further than it
+	furthest := pos
+	for int(pos) < len(d.mem) {
+		inst, err := d.Decode(pos)
+		if err != nil {
+			r.Err = err
+			return
+		}
+
+		r.Instructions = append(r.Instructions, inst)
+		pos += uint32(len(inst.Bytes))
+
+		if inst.Branch != nil && inst.Branch.Return < 0 {
+			furthest = max(furthest, inst.Branch.Target)
+		}
+		if inst.Name == "jump" {
+			furthest = max(furthest, inst.Target)
+		}
+
+		if endOps[inst.Name] && pos > furthest {
+			return
+		}
+	}
+}
+
+// Name of a variable, the stack, a local or a global
+// See: https://zspec.jaredreisinger.com/04-instructions#4_2_2
+func varName(v uint16) string {
+	switch {
+	case v == 0:
+		return "sp"
+	case v < 0x10:
+		return fmt.Sprintf("L%02X", v-1)
+	default:
+		return fmt.Sprintf("G%02X", v-0x10)
+	}
+}
+
+// String gives the instruction as it would be written in assembly. Constants start with #,
+// large ones having four digits and small ones two, variables are sp, Lnn for locals or Gnn
+// for globals, and [var] means the variable named by the value of var
+func (inst *Instruction) String() string {
+	parts := []string{inst.Name}
+
+	for i, op := range inst.Operands {
+		switch {
+		case i == 0 && inst.Target != 0:
+			parts = append(parts, fmt.Sprintf("%05X", inst.Target))
+		case i == 0 && indirectOps[inst.Name] && op.Type == OPTYPE_VARIABLE:
+			parts = append(parts, "["+varName(op.Value)+"]")
+		case i == 0 && indirectOps[inst.Name]:
+			parts = append(parts, varName(op.Value))
+		case op.Type == OPTYPE_LARGE_CONST:
+			parts = append(parts, fmt.Sprintf("#%04X", op.Value))
+		case op.Type == OPTYPE_SMALL_CONST:
+			parts = append(parts, fmt.Sprintf("#%02X", op.Value))
+		default:
+			parts = append(parts, varName(op.Value))
+		}
+	}
+
+	if inst.Text != "" {
+		parts = append(parts, fmt.Sprintf("%q", inst.Text))
+	}
+
+	if inst.Store >= 0 {
+		parts = append(parts, "->", varName(uint16(inst.Store)))
+	}
+
+	if b := inst.Branch; b != nil {
+		cond := "?"
+		if !b.OnTrue {
+			cond = "?~"
+		}
+
+		switch b.Return {
+		case 0:
+			parts = append(parts, cond+"rfalse")
+		case 1:
+			parts = append(parts, cond+"rtrue")
+		default:
+			parts = append(parts, fmt.Sprintf("%s%05X", cond, b.Target))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Line gives the address, the bytes and the instruction, as it's shown in a listing
+// Long instructions have their bytes cut short, it's mostly inline text
+func (inst *Instruction) Line() string {
+	bytes := fmt.Sprintf("% X", inst.Bytes)
+	if len(inst.Bytes) > 8 {
+		bytes = fmt.Sprintf("% X ..", inst.Bytes[:8])
+	}
+
+	return fmt.Sprintf("%05X: %-26s %s", inst.Addr, bytes, inst.String())
+}
+
+// Write disassembles the whole story, listing each routine in address order
+func (d *Disassembler) Write(w io.Writer) {
+	routines := d.Routines()
+	fmt.Fprintf(w, "Z-machine version %d story, %d routines found from the start at %05X\n",
+		d.version, len(routines), d.initialPC)
+
+	for _, r := range routines {
+		fmt.Fprintln(w)
+
+		switch {
+		case r.Main:
+			fmt.Fprintf(w, "Main routine %05X\n", r.Addr)
+		case len(r.CalledFrom) > 0:
+			callers := make([]string, len(r.CalledFrom))
+			for i, addr := range r.CalledFrom {
+				callers[i] = fmt.Sprintf("%05X", addr)
+			}
+			fmt.Fprintf(w, "Routine %05X, called from %s\n", r.Addr, strings.Join(callers, " "))
+		default:
+			fmt.Fprintf(w, "Routine %05X\n", r.Addr)
+		}
+
+		if len(r.Locals) > 0 {
+			locals := make([]string, len(r.Locals))
+			for i, v := range r.Locals {
+				locals[i] = fmt.Sprintf("%s=#%04X", varName(uint16(i+1)), v)
+			}
+			fmt.Fprintf(w, "  Locals: %s\n", strings.Join(locals, " "))
+		}
+
+		for _, inst := range r.Instructions {
+			fmt.Fprintf(w, "  %s\n", inst.Line())
+		}
+
+		if r.Err != nil {
+			fmt.Fprintf(w, "  Error: %s\n", r.Err)
+		}
+	}
+}
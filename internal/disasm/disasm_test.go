@@ -0,0 +1,176 @@
+// ============================================================================
+// GoZm - Z-Machine interpreter written in Go
+// Copyright (c) 2025 - Ben Coleman
+// Checks the disassembly of the test stories against the dumps held beside them
+// ============================================================================
+
+package disasm
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fixture is the Z-code section of a test/*.dump.txt file, as written by the unz tool
+type fixture struct {
+	routines []uint32 // Routine header addresses, the main routine first
+	insts    map[uint32]fixtureInst
+}
+
+type fixtureInst struct {
+	bytes []byte
+	name  string
+}
+
+// Instruction lines have the address, then the bytes up to column 30 and the instruction after
+// that. Instructions too long for the column carry on over lines starting with spaces
+func loadFixture(t *testing.T, path string) fixture {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	f := fixture{insts: map[uint32]fixtureInst{}}
+	inCode, skipping := false, false
+	var last uint32
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " ")
+
+		switch {
+		case strings.HasPrefix(line, "*****"):
+			if inCode {
+				return f
+			}
+			inCode = strings.Contains(line, "Z-CODE")
+			continue
+		case !inCode:
+			continue
+		case line == "":
+			skipping = false
+			continue
+		case skipping:
+			continue
+		}
+
+		// Routine headers and padding are followed by lines of their own, up to a blank line
+		var addr uint32
+		if _, err := fmt.Sscanf(strings.TrimPrefix(line, "Main "), "Routine: 0x%X", &addr); err == nil {
+			f.routines = append(f.routines, addr)
+			skipping = true
+			continue
+		}
+		if _, err := fmt.Sscanf(strings.TrimPrefix(line, "Main "), "routine: 0x%X", &addr); err == nil {
+			f.routines = append(f.routines, addr)
+			skipping = true
+			continue
+		}
+		if line == "Padding:" {
+			skipping = true
+			continue
+		}
+
+		col := min(len(line), 30)
+		data, err := hex.DecodeString(strings.ReplaceAll(line[6:col], " ", ""))
+		if err != nil {
+			t.Fatalf("bad bytes in %q: %v", line, err)
+		}
+
+		if !strings.HasPrefix(line, "      ") {
+			if _, err := fmt.Sscanf(line[:5], "%X", &last); err != nil {
+				t.Fatalf("bad address in %q: %v", line, err)
+			}
+		}
+
+		inst := f.insts[last]
+		inst.bytes = append(inst.bytes, data...)
+		if col < len(line) {
+			inst.name = strings.Fields(line[col:])[0]
+		}
+		f.insts[last] = inst
+	}
+
+	return f
+}
+
+// The unz tool calls sread by its name from the Inform assembler
+var unzNames = map[string]string{
+	"sread": "read",
+}
+
+func TestRoutinesMatchFixtures(t *testing.T) {
+	stories, err := filepath.Glob("../../test/*.z3")
+	if err != nil || len(stories) == 0 {
+		t.Fatalf("no test stories found: %v", err)
+	}
+
+	for _, storyPath := range stories {
+		t.Run(filepath.Base(storyPath), func(t *testing.T) {
+			story, err := os.ReadFile(storyPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := loadFixture(t, strings.TrimSuffix(storyPath, ".z3")+".dump.txt")
+
+			d, err := New(story)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The main routine is listed by where it starts running, after its header
+			got := []uint32{}
+			count := 0
+			for _, r := range d.Routines() {
+				if r.Err != nil {
+					t.Errorf("routine %05X: %v", r.Addr, r.Err)
+				}
+
+				addr := r.Addr
+				if r.Main {
+					addr--
+				}
+				got = append(got, addr)
+
+				for _, inst := range r.Instructions {
+					count++
+					w, ok := want.insts[inst.Addr]
+					if !ok {
+						t.Errorf("%05X: %s isn't in the fixture", inst.Addr, inst.Name)
+						continue
+					}
+
+					if !slices.Equal(inst.Bytes, w.bytes) {
+						t.Errorf("%05X: bytes are % X, want % X", inst.Addr, inst.Bytes, w.bytes)
+					}
+
+					name := inst.Name
+					if unz, ok := unzNames[name]; ok {
+						name = unz
+					}
+					if !strings.EqualFold(name, w.name) {
+						t.Errorf("%05X: instruction is %s, want %s", inst.Addr, inst.Name, w.name)
+					}
+				}
+			}
+
+			slices.Sort(got)
+			slices.Sort(want.routines)
+			if !slices.Equal(got, want.routines) {
+				t.Errorf("routines are %05X, want %05X", got, want.routines)
+			}
+			if count != len(want.insts) {
+				t.Errorf("%d instructions found, want %d", count, len(want.insts))
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// ============================================================================
+// GoZm - Z-Machine interpreter written in Go
+// Copyright (c) 2025 - Ben Coleman
+// What each opcode stores, branches on or carries inline, needed to find where it ends
+// ============================================================================
+
+package disasm
+
+// Instructions that store a result in the variable given by the byte after their operands
+// See: https://zspec.jaredreisinger.com/14-opcode-table
+var storeOps = map[string]bool{
+	"or": true, "and": true, "loadw": true, "loadb": true, "get_prop": true, "get_prop_addr": true,
+	"get_next_prop": true, "add": true, "sub": true, "mul": true, "div": true, "mod": true,
+	"call_2s": true, "get_sibling": true, "get_child": true, "get_parent": true, "get_prop_len": true,
+	"load": true, "call_1s": true, "not": true, "catch": true, "call": true, "call_vs": true,
+	"call_vs2": true, "random": true, "read_char": true, "scan_table": true, "aread": true,
+}
+
+// Extended instructions that store, these are numbered separately so are kept apart
+var extStoreOps = map[string]bool{
+	"save": true, "restore": true, "log_shift": true, "art_shift": true, "set_font": true,
+	"save_undo": true, "restore_undo": true, "check_unicode": true,
+}
+
+// Instructions followed by branch data, after the store byte if they have one
+var branchOps = map[string]bool{
+	"je": true, "jl": true, "jg": true, "dec_chk": true, "inc_chk": true, "jin": true, "test": true,
+	"test_attr": true, "jz": true, "get_sibling": true, "get_child": true, "verify": true,
+	"piracy": true, "scan_table": true, "check_arg_count": true,
+}
+
+// Instructions with an encoded string following them
+var textOps = map[string]bool{
+	"print": true, "print_ret": true,
+}
+
+// Instructions that never carry on to the next one, so can end a routine
+var endOps = map[string]bool{
+	"rtrue": true, "rfalse": true, "print_ret": true, "ret": true, "ret_popped": true,
+	"jump": true, "quit": true, "restart": true, "throw": true,
+}
+
+// Instructions where the first operand is a packed routine address
+var callOps = map[string]bool{
+	"call": true, "call_vs": true, "call_vs2": true, "call_vn": true, "call_vn2": true,
+	"call_1s": true, "call_1n": true, "call_2s": true, "call_2n": true,
+}
+
+// Instructions where the first operand is the number of a variable, rather than its value
+var indirectOps = map[string]bool{
+	"inc": true, "dec": true, "inc_chk": true, "dec_chk": true, "store": true, "load": true, "pull": true,
+}
+
+// Instructions added after v3, with the version that added them. The opcode tables don't
+// hold this as the machine runs whatever it's given, but it helps tell code from data
+var minVersions = map[string]byte{
+	"call_1s": 4, "call_2s": 4, "call_vs": 4, "call_vs2": 4, "erase_line": 4, "set_cursor": 4,
+	"get_cursor": 4, "set_text_style": 4, "buffer_mode": 4, "read_char": 4, "scan_table": 4,
+	"call_1n": 5, "call_2n": 5, "call_vn": 5, "call_vn2": 5, "set_colour": 5, "throw": 5, "catch": 5,
+	"tokenise": 5, "encode_text": 5, "copy_table": 5, "print_table": 5, "check_arg_count": 5,
+	"piracy": 5, "aread": 5,
+}
+
+// Whether an instruction stores and branches, which for save & restore depends on the version
+// See: https://zspec.jaredreisinger.com/15-opcodes#save
+func shape(name string, ext bool, version byte) (store bool, branch bool) {
+	if ext {
+		return extStoreOps[name], false
+	}
+
+	if name == "save" || name == "restore" {
+		return version == 4, version <= 3
+	}
+
+	if name == "pull" {
+		return version == 6, false
+	}
+
+	return storeOps[name], branchOps[name]
+}
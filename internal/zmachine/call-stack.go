@@ -7,11 +7,18 @@
 
 package zmachine
 
+import "github.com/benc-uk/gozm/internal/decode"
+
 // CallFrame represents a single routine call in the Z-machine call stack
 type CallFrame struct {
-	ReturnAddr uint32   `json:"return_addr"`
+	ReturnAddr uint32   `json:"return_addr"` // Address of the store byte following the call, or the next instruction if Discard is set
+	Routine    uint32   `json:"routine"`     // Address of the routine called, 0 for the main routine or a frame from a save file
 	Locals     []uint16 `json:"locals"`
 	Stack      []uint16 `json:"stack"`
+	NumLocals  byte     `json:"num_locals"` // Locals declared by the routine header
+	ArgCount   byte     `json:"arg_count"`  // Arguments supplied by the caller
+	Interrupt  bool     `json:"interrupt"`  // Called by the interpreter, e.g. timed input, rather than a call opcode
+	Discard    bool     `json:"discard"`    // Called by a v5 call_*n opcode, which has no store byte
 }
 
 // Push a value onto the call frame stack
@@ -35,6 +42,16 @@ func (cf *CallFrame) Pop() uint16 {
 	return val
 }
 
+// popStack pops the routine stack, reporting an error if it's empty
+func (m *Machine) popStack() uint16 {
+	cf := m.getCallFrame()
+	if len(cf.Stack) == 0 {
+		m.reportError(ERR_STACK_UNDERFLOW, "pop from empty stack")
+	}
+
+	return cf.Pop()
+}
+
 // Some ops need to just peek at the top value of the stack
 func (cf *CallFrame) Peek() uint16 {
 	if len(cf.Stack) == 0 {
@@ -77,12 +94,107 @@ func (m *Machine) addCallFrame() *CallFrame {
 	return &m.callStack[len(m.callStack)-1]
 }
 
+// callRoutine calls the routine at a packed address with the given arguments
+// The return address is the store byte following the calling instruction, unless
+// discard is set, in which case there is no store byte and the result is thrown away
+func (m *Machine) callRoutine(packedAddr uint16, args []uint16, returnAddr uint32, discard bool) {
+	routineAddr := m.unpackRoutine(packedAddr)
+
+	// Count locals from routine header, anything that isn't a routine gives false as if it returned
+	numLocals := byte(0xFF)
+	if int(routineAddr) < len(m.mem) {
+		numLocals = m.mem[routineAddr]
+	}
+	if numLocals > 15 {
+		m.reportError(ERR_INVALID_ROUTINE, "call to %08X which is not a routine", routineAddr)
+		routineAddr = 0
+	}
+
+	// When the address 0 is called as a routine, nothing happens and the return value is false
+	if routineAddr == 0 {
+		m.debug(" - call to NULL routine, returning false\n")
+		if discard {
+			m.pc = returnAddr
+			return
+		}
+
+		m.storeVar(uint16(m.mem[returnAddr]), 0)
+		m.pc = returnAddr + 1
+		return
+	}
+
+	m.debug(" - call to %08x with %d locals\n", routineAddr, numLocals)
+
+	// Push new stack frame
+	frame := m.addCallFrame()
+	frame.ReturnAddr = returnAddr
+	frame.Routine = routineAddr
+	frame.NumLocals = numLocals
+	frame.ArgCount = byte(min(len(args), int(numLocals)))
+	frame.Discard = discard
+
+	// Up to v4 the routine header has initial values for the locals, from v5 they start as zero
+	// Note: Many compilers don't initialize locals, so this step may be unnecessary
+	codeAddr := routineAddr + 1
+	if m.version < 5 {
+		for i := byte(0); i < numLocals; i++ {
+			localVal := decode.GetWord32(m.mem, routineAddr+1+uint32(i*2))
+			m.trace(" - local init %d = %d\n", i, localVal)
+			frame.Locals[i] = localVal
+		}
+		codeAddr += uint32(numLocals) * 2
+	}
+
+	// Push arguments into local variables, any beyond the number of locals are dropped
+	for i, argVal := range args {
+		if i >= int(numLocals) {
+			break
+		}
+		frame.Locals[i] = argVal
+		m.trace(" - arg %d = %d\n", i, argVal)
+	}
+
+	// Set PC to start of routine after the header
+	m.pc = codeAddr
+}
+
+// callInterrupt runs a routine through to its return from inside another instruction
+// Used for the routines called by timed input, the PC is left where it was
+func (m *Machine) callInterrupt(packedAddr uint16) uint16 {
+	if m.unpackRoutine(packedAddr) == 0 {
+		return 0
+	}
+
+	depth := len(m.callStack)
+	m.callRoutine(packedAddr, nil, m.pc, false)
+	m.getCallFrame().Interrupt = true
+
+	for len(m.callStack) > depth && m.exitCode == 0 {
+		m.step()
+	}
+
+	return m.interruptResult
+}
+
 // Helper to return from a call with a value
 func (m *Machine) returnFromCall(val uint16) {
 	frame := m.getCallFrame()
 	m.pc = frame.ReturnAddr
 	m.callStack = m.callStack[:len(m.callStack)-1]
 
+	// Interrupt routines return to the interpreter, not to a store byte
+	if frame.Interrupt {
+		m.trace("Return from interrupt: PC restored to %08X, result %d\n", frame.ReturnAddr, val)
+		m.interruptResult = val
+		return
+	}
+
+	// Calls which discard their result have no store byte, the PC is already at the next instruction
+	if frame.Discard {
+		m.trace("Return: PC restored to %08X, result %d discarded\n", frame.ReturnAddr, val)
+		return
+	}
+
 	// The next byte after a CALL is the variable to store the result in
 	resultStoreLoc := m.mem[m.pc]
 	m.trace("Return: PC restored to %08X, store byte=%02X, will advance to %08X\n", frame.ReturnAddr, resultStoreLoc, frame.ReturnAddr+1)
@@ -14,10 +14,10 @@ import (
 )
 
 // DumpMem dumps a section of memory for debugging
-func (m *Machine) DumpMem(addr uint16, length uint16) {
+func (m *Machine) DumpMem(addr uint32, length uint32) {
 	fmt.Printf("\nMemory dump at %04x:\n", addr)
-	for i := uint16(0); i < length; i += 2 {
-		word := decode.GetWord(m.mem, addr+i)
+	for i := uint32(0); i < length; i += 2 {
+		word := decode.GetWord32(m.mem, addr+i)
 		fmt.Printf("%04x: %04x (%04d)\n", addr+i, word, word)
 	}
 }
@@ -35,9 +35,9 @@ func (m *Machine) trace(format string, a ...interface{}) {
 }
 
 // Internal helper to dump object properties for debugging
-func (o *zObject) propDebugDump() string {
+func (v *ObjectView) propDebugDump() string {
 	result := ""
-	for _, propData := range o.Props {
+	for _, propData := range v.Props {
 		result += fmt.Sprintf("    Prop num:%d, size:%d, data:%x\n", propData.Num, propData.Size, propData.Data)
 	}
 	return result
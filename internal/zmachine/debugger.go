@@ -0,0 +1,395 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// debugger.go - Interactive debugger, with breakpoints, stepping and inspecting state
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/benc-uk/gozm/internal/decode"
+	"github.com/benc-uk/gozm/internal/disasm"
+)
+
+// How the debugger decides when to next stop, it always stops at a breakpoint too
+const (
+	DEBUGGER_CONTINUE = 0 // Only at a breakpoint
+	DEBUGGER_STEP     = 1 // At the next instruction, which might be in a called routine
+	DEBUGGER_NEXT     = 2 // At the next instruction in this routine, running calls to the end
+	DEBUGGER_FINISH   = 3 // Once the current routine returns
+
+	DEBUGGER_LIST_LINES = 5 // Instructions listed either side of the PC
+)
+
+// debugger is the state of the interactive debugger, it has the player's attention whenever it stops
+// and runs commands until one of them sets the game going again
+type debugger struct {
+	breakpoints map[uint32]bool // Addresses of instructions to stop at
+	mode        int             // One of the DEBUGGER_* values
+	depth       int             // Call stack depth when stepping over or finishing
+	lastCommand string          // Repeated when an empty line is entered
+	dis         *disasm.Disassembler
+}
+
+// StartDebugger switches on the debugger, which stops before the next instruction is run
+func (m *Machine) StartDebugger() {
+	if m.debugger == nil {
+		dis, err := disasm.New(m.mem)
+		if err != nil {
+			m.print(fmt.Sprintf("Unable to start debugger: %s\n", err))
+			return
+		}
+
+		m.debugger = &debugger{breakpoints: map[uint32]bool{}, dis: dis}
+		m.ext.TextOut("[Debugger started, type help for a list of commands]\n")
+	}
+
+	m.debugger.mode = DEBUGGER_STEP
+}
+
+// Called by step before each instruction, when the debugger is on, to see if it should stop
+// Commands are then taken until the game is set going again
+func (d *debugger) check(m *Machine) {
+	depth := len(m.callStack)
+	stop := d.breakpoints[m.pc]
+	switch d.mode {
+	case DEBUGGER_STEP:
+		stop = true
+	case DEBUGGER_NEXT:
+		stop = stop || depth <= d.depth
+	case DEBUGGER_FINISH:
+		stop = stop || depth < d.depth
+	}
+
+	if !stop {
+		return
+	}
+
+	d.mode = DEBUGGER_CONTINUE
+	d.showCurrent(m)
+
+	for m.debugger != nil && m.exitCode == 0 {
+		m.ext.TextOut("(debug) ")
+		line, _ := m.ext.ReadInput(0)
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = d.lastCommand
+		}
+		d.lastCommand = line
+
+		if d.command(m, line) {
+			return
+		}
+	}
+}
+
+// Runs a debugger command, returning true if it sets the game going again
+func (d *debugger) command(m *Machine, line string) bool {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "s", "step":
+		d.mode = DEBUGGER_STEP
+		return true
+
+	case "n", "next":
+		d.mode, d.depth = DEBUGGER_NEXT, len(m.callStack)
+		return true
+
+	case "f", "finish":
+		d.mode, d.depth = DEBUGGER_FINISH, len(m.callStack)
+		return true
+
+	case "c", "continue":
+		return true
+
+	case "b", "break":
+		addr, ok := d.breakAddr(m, args[1:])
+		if ok {
+			d.breakpoints[addr] = true
+			m.ext.TextOut(fmt.Sprintf("Breakpoint set at %05X\n", addr))
+		}
+
+	case "d", "delete":
+		addr, ok := d.breakAddr(m, args[1:])
+		if ok && d.breakpoints[addr] {
+			delete(d.breakpoints, addr)
+			m.ext.TextOut(fmt.Sprintf("Breakpoint at %05X deleted\n", addr))
+		} else if ok {
+			m.ext.TextOut(fmt.Sprintf("No breakpoint at %05X\n", addr))
+		}
+
+	case "breakpoints":
+		if len(d.breakpoints) == 0 {
+			m.ext.TextOut("No breakpoints\n")
+		}
+		for _, addr := range slices.Sorted(maps.Keys(d.breakpoints)) {
+			m.ext.TextOut(fmt.Sprintf("  %05X\n", addr))
+		}
+
+	case "bt", "backtrace":
+		d.backtrace(m)
+
+	case "l", "list":
+		addr := m.pc
+		if len(args) > 1 {
+			var ok bool
+			if addr, ok = d.parseAddr(m, args[1]); !ok {
+				return false
+			}
+		}
+		d.list(m, addr)
+
+	case "g", "global", "globals":
+		d.globals(m, args[1:])
+
+	case "o", "object":
+		d.object(m, args[1:])
+
+	case "detach":
+		m.debugger = nil
+		m.ext.TextOut("[Debugger stopped, use /debug to start it again]\n")
+		return true
+
+	case "q", "quit":
+		m.exitCode = EXIT_QUIT
+		return true
+
+	case "h", "help":
+		m.ext.TextOut(`Commands, addresses and globals are in hex, objects are in decimal:
+  s, step              Run one instruction, stepping into calls
+  n, next              Run one instruction, running calls through to their return
+  f, finish            Run until the current routine returns
+  c, continue          Run until a breakpoint
+  b, break ADDR        Stop at the instruction at ADDR
+  b, break routine ADDR  Stop at the start of the routine at ADDR
+  d, delete ADDR       Remove a breakpoint, routine ADDR works here too
+  breakpoints          List the breakpoints
+  bt, backtrace        Show the call stack, with the locals and stack of each routine
+  l, list [ADDR]       Disassemble around the PC, or around ADDR
+  g, globals [N]       Show all the globals, or just global N
+  o, object N          Show object N with its attributes and properties
+  detach               Stop debugging and carry on with the game
+  q, quit              Quit the game
+An empty line repeats the last command
+`)
+
+	default:
+		m.ext.TextOut(fmt.Sprintf("Unknown command %q, type help for a list of commands\n", args[0]))
+	}
+
+	return false
+}
+
+// Shows where execution has stopped, as the instruction about to run
+func (d *debugger) showCurrent(m *Machine) {
+	inst, err := d.dis.Decode(m.pc)
+	if err != nil {
+		m.ext.TextOut(fmt.Sprintf("\nStopped at %05X: %s\n", m.pc, err))
+		return
+	}
+
+	m.ext.TextOut(fmt.Sprintf("\nStopped at %s\n", inst.Line()))
+}
+
+// Parses a hex address, which may be given with 0x or $ in front
+func (d *debugger) parseAddr(m *Machine, arg string) (uint32, bool) {
+	arg = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(arg), "0x"), "$")
+	addr, err := strconv.ParseUint(arg, 16, 32)
+	if err != nil || int(addr) >= len(m.mem) {
+		m.ext.TextOut(fmt.Sprintf("Invalid address %q\n", arg))
+		return 0, false
+	}
+
+	return uint32(addr), true
+}
+
+// Gets the address a breakpoint is for, from an instruction address or "routine ADDR"
+func (d *debugger) breakAddr(m *Machine, args []string) (uint32, bool) {
+	if len(args) == 2 && (args[0] == "routine" || args[0] == "r") {
+		addr, ok := d.parseAddr(m, args[1])
+		if !ok {
+			return 0, false
+		}
+
+		code, ok := routineCode(m, addr)
+		if !ok {
+			m.ext.TextOut(fmt.Sprintf("There is no routine at %05X\n", addr))
+		}
+		return code, ok
+	}
+
+	if len(args) != 1 {
+		m.ext.TextOut("Give an address, or routine and an address\n")
+		return 0, false
+	}
+
+	return d.parseAddr(m, args[0])
+}
+
+// Gets the address of the first instruction in a routine, after its header
+// See: https://zspec.jaredreisinger.com/05-routines
+func routineCode(m *Machine, routine uint32) (uint32, bool) {
+	numLocals := m.mem[routine]
+	if numLocals > 15 {
+		return 0, false
+	}
+
+	if m.version < 5 {
+		return routine + 1 + uint32(numLocals)*2, true
+	}
+	return routine + 1, true
+}
+
+// Shows each routine in the call stack, most recent first, with its locals and stack
+// The current routine is at the PC, the others carry on from where the routine they called returns to
+func (d *debugger) backtrace(m *Machine) {
+	at := fmt.Sprintf("at %05X", m.pc)
+	for i := len(m.callStack) - 1; i >= 0; i-- {
+		frame := m.callStack[i]
+
+		name := fmt.Sprintf("routine %05X", frame.Routine)
+		if i == 0 {
+			name = "main"
+		} else if frame.Routine == 0 {
+			name = "routine ?"
+		}
+		if frame.Interrupt {
+			name += " (interrupt)"
+		}
+
+		m.ext.TextOut(fmt.Sprintf("#%d %s %s\n", len(m.callStack)-1-i, name, at))
+
+		locals := make([]string, frame.NumLocals)
+		for l := range locals {
+			locals[l] = fmt.Sprintf("L%02X=%04X", l, frame.Locals[l])
+		}
+		m.ext.TextOut(fmt.Sprintf("    Locals: %s\n", strings.Join(locals, " ")))
+
+		stack := make([]string, len(frame.Stack))
+		for s, v := range frame.Stack {
+			stack[s] = fmt.Sprintf("%04X", v)
+		}
+		m.ext.TextOut(fmt.Sprintf("    Stack:  %s\n", strings.Join(stack, " ")))
+
+		at = fmt.Sprintf("resuming at %05X", frame.ReturnAddr)
+	}
+}
+
+// Disassembles the instructions around an address. Z-code can only be decoded forwards so the
+// current routine is decoded from its start, or if it's not known from the address itself
+func (d *debugger) list(m *Machine, addr uint32) {
+	start := addr
+	if frame := m.getCallFrame(); addr == m.pc {
+		if len(m.callStack) == 1 {
+			start = uint32(m.initialPC)
+		} else if code, ok := routineCode(m, frame.Routine); ok && frame.Routine != 0 {
+			start = code
+		}
+	}
+
+	// Stop once there are enough lines after the address, or decoding fails
+	var insts []*disasm.Instruction
+	current := -1
+	for pos := start; len(insts) < 4096; {
+		inst, err := d.dis.Decode(pos)
+		if err != nil {
+			break
+		}
+
+		if inst.Addr == addr {
+			current = len(insts)
+		}
+		insts = append(insts, inst)
+		pos += uint32(len(inst.Bytes))
+
+		if pos > addr && len(insts)-current > DEBUGGER_LIST_LINES {
+			break
+		}
+	}
+
+	// The address wasn't on an instruction boundary, so just list from it
+	if current < 0 && start != addr {
+		start, insts, current = addr, nil, 0
+		for pos := addr; len(insts) <= DEBUGGER_LIST_LINES; {
+			inst, err := d.dis.Decode(pos)
+			if err != nil {
+				break
+			}
+			insts = append(insts, inst)
+			pos += uint32(len(inst.Bytes))
+		}
+	}
+
+	from := max(0, current-DEBUGGER_LIST_LINES)
+	to := min(len(insts), current+DEBUGGER_LIST_LINES+1)
+	for i := from; i < to; i++ {
+		marker := "  "
+		if insts[i].Addr == m.pc {
+			marker = "=>"
+		} else if d.breakpoints[insts[i].Addr] {
+			marker = "* "
+		}
+		m.ext.TextOut(fmt.Sprintf("%s %s\n", marker, insts[i].Line()))
+	}
+}
+
+// Shows a single global, given by its number in hex, or all of them
+func (d *debugger) globals(m *Machine, args []string) {
+	if len(args) > 0 {
+		num, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(args[0]), "G"), 16, 8)
+		if err != nil || num > 0xEF {
+			m.ext.TextOut(fmt.Sprintf("Invalid global %q, globals go from 00 to EF\n", args[0]))
+			return
+		}
+
+		val := decode.GetWord(m.mem, m.globalsAddr+uint16(num)*2)
+		m.ext.TextOut(fmt.Sprintf("G%02X = %04X (%d)\n", num, val, int16(val)))
+		return
+	}
+
+	// All 240 globals, eight to a line
+	for num := uint16(0); num < 0xF0; num++ {
+		m.ext.TextOut(fmt.Sprintf("G%02X=%04X ", num, decode.GetWord(m.mem, m.globalsAddr+num*2)))
+		if num%8 == 7 {
+			m.ext.TextOut("\n")
+		}
+	}
+}
+
+// Shows an object, given by its number in decimal
+func (d *debugger) object(m *Machine, args []string) {
+	if len(args) != 1 {
+		m.ext.TextOut("Give the number of an object\n")
+		return
+	}
+
+	num, err := strconv.ParseUint(args[0], 10, 16)
+	if err != nil || num == 0 || num > uint64(m.objectCount) {
+		m.ext.TextOut(fmt.Sprintf("Invalid object %q, objects go from 1 to %d\n", args[0], m.objectCount))
+		return
+	}
+
+	v := m.getObject(uint16(num)).view()
+	attrs := []string{}
+	for i, set := range v.Attrs {
+		if set {
+			attrs = append(attrs, fmt.Sprint(i))
+		}
+	}
+
+	m.ext.TextOut(fmt.Sprintf("%d. %q\n", v.Num, v.Desc))
+	m.ext.TextOut(fmt.Sprintf("    Parent:%d Sibling:%d Child:%d\n", v.Parent, v.Sibling, v.Child))
+	m.ext.TextOut(fmt.Sprintf("    Attributes: %s\n", strings.Join(attrs, " ")))
+	m.ext.TextOut(v.propDebugDump())
+}
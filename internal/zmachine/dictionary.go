@@ -0,0 +1,157 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// dictionary.go - Dictionaries and text buffers
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/benc-uk/gozm/internal/decode"
+)
+
+// dictionary is a table of words that input is matched against, the game's main one is given
+// in the header, but from v5 tokenise can be pointed at others
+// See: https://zspec.jaredreisinger.com/13-dictionary
+type dictionary struct {
+	addr      uint16      // Address of the dictionary header
+	sep       []byte      // Word separator characters, as ZSCII
+	entries   []dictEntry // Words in the dictionary
+	startAddr uint16      // Start address of the entries
+	sorted    bool        // Entries are in numerical order of their encoded words, so can be searched
+}
+
+// dictEntry is a word held in its encoded form, as that's what input is matched on
+type dictEntry struct {
+	key     []uint16
+	address uint16
+}
+
+// loadDictionary reads the dictionary at the given address
+func (m *Machine) loadDictionary(addr uint16) *dictionary {
+	d := &dictionary{addr: addr, sorted: true}
+
+	numSepBytes := m.mem[addr]
+	d.sep = make([]byte, numSepBytes)
+	copy(d.sep, m.mem[addr+1:addr+1+uint16(numSepBytes)])
+	entryLen := m.mem[addr+1+uint16(numSepBytes)]
+
+	// A negative count is allowed for a dictionary given to tokenise, it means the entries aren't sorted
+	numEntries := decode.GetWordSigned(m.mem, addr+2+uint16(numSepBytes))
+	if numEntries < 0 {
+		numEntries = -numEntries
+		d.sorted = false
+	}
+
+	// Load dictionary entries, the encoded word is 2 words long up to v3 and 3 words after
+	keyLen := uint16(len(m.text.Encode("")))
+	d.entries = make([]dictEntry, numEntries)
+	d.startAddr = addr + 2 + uint16(numSepBytes) + 2
+	for i := uint16(0); i < uint16(numEntries); i++ {
+		entryAddr := d.startAddr + i*uint16(entryLen)
+		key := make([]uint16, keyLen)
+		for k := range key {
+			key[k] = decode.GetWord(m.mem, entryAddr+uint16(k*2))
+		}
+		d.entries[i] = dictEntry{
+			key:     key,
+			address: entryAddr,
+		}
+	}
+
+	return d
+}
+
+// lookup finds a word in the dictionary by its encoded form and returns the address of its entry
+// Returns 0 if the word is not found, as the parse table expects
+// See: https://zspec.jaredreisinger.com/13-dictionary#13_6
+func (d *dictionary) lookup(word string, text *decode.Decoder) uint16 {
+	key := text.Encode(strings.ToLower(word))
+
+	if !d.sorted {
+		for _, entry := range d.entries {
+			if compareKeys(entry.key, key) == 0 {
+				return entry.address
+			}
+		}
+		return 0
+	}
+
+	i := sort.Search(len(d.entries), func(i int) bool {
+		return compareKeys(d.entries[i].key, key) >= 0
+	})
+	if i < len(d.entries) && compareKeys(d.entries[i].key, key) == 0 {
+		return d.entries[i].address
+	}
+
+	return 0
+}
+
+// Orders encoded words as the dictionary is sorted, treating them as one big unsigned number
+func compareKeys(a, b []uint16) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Offset of the first character in a text buffer, from v5 there is a length byte before the text
+func (m *Machine) textStart() uint16 {
+	if m.version >= 5 {
+		return 2
+	}
+	return 1
+}
+
+// writeTextBuffer stores input in a text buffer as ZSCII, cutting it to fit the buffer
+// Up to v4 the text is zero terminated, from v5 the length is stored in the second byte
+// See: https://zspec.jaredreisinger.com/15-opcodes#read
+func (m *Machine) writeTextBuffer(textAddr uint16, text string) {
+	maxLen := int(m.mem[textAddr])
+	if m.version < 5 {
+		maxLen-- // Weirdly, the first byte is the max length, so reduce by 1 for the terminator
+	}
+
+	// Characters with no ZSCII code are stored as question marks
+	input := make([]byte, 0, len(text))
+	for _, r := range text {
+		code, _ := m.text.ZSCIICode(r)
+		input = append(input, byte(code))
+	}
+
+	if len(input) > maxLen {
+		input = input[:maxLen]
+	}
+
+	start := textAddr + m.textStart()
+	copy(m.mem[start:], input)
+
+	if m.version >= 5 {
+		m.mem[textAddr+1] = byte(len(input))
+	} else {
+		m.mem[start+uint16(len(input))] = 0 // Null terminate, important!
+	}
+}
+
+// readTextBuffer gets the ZSCII held in a text buffer, as written by writeTextBuffer
+func (m *Machine) readTextBuffer(textAddr uint16) []byte {
+	start := textAddr + m.textStart()
+	if m.version >= 5 {
+		return m.mem[start : start+uint16(m.mem[textAddr+1])]
+	}
+
+	end := start
+	for m.mem[end] != 0 {
+		end++
+	}
+	return m.mem[start:end]
+}
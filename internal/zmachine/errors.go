@@ -0,0 +1,144 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// errors.go - Runtime errors, which stop the machine and are returned from Run
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuntimeError is returned from Run when the game does something the machine can't carry on from
+// It holds where it happened and the call stack at the time, to help work out what went wrong
+type RuntimeError struct {
+	PC          uint32      // Address of the instruction that failed
+	Instruction string      // The decoded instruction, if it got that far
+	CallStack   []CallFrame // Copy of the call stack, the current routine is last
+	Cause       error
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("runtime error at %08X: %s: %s", e.PC, e.Instruction, e.Cause)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Cause
+}
+
+// StackTrace describes the call stack, most recent routine first
+func (e *RuntimeError) StackTrace() string {
+	var sb strings.Builder
+	for i := len(e.CallStack) - 1; i >= 0; i-- {
+		sb.WriteString(fmt.Sprintf(" - Frame %d: return to %08X\n", i, e.CallStack[i].ReturnAddr))
+	}
+
+	return sb.String()
+}
+
+// Panics inside the machine are turned into a RuntimeError for the instruction being run
+// One raised in a nested step, e.g. an interrupt routine, already is one so is passed on untouched
+func (m *Machine) runtimeError(r any, inst instruction) *RuntimeError {
+	if rerr, ok := r.(*RuntimeError); ok {
+		return rerr
+	}
+
+	cause, ok := r.(error)
+	if !ok {
+		cause = fmt.Errorf("%v", r)
+	}
+
+	// The instruction is left empty if the panic came while decoding it
+	name := ""
+	if inst.len > 0 {
+		name = inst.String()
+	}
+
+	return &RuntimeError{
+		PC:          m.pc,
+		Instruction: name,
+		CallStack:   copyCallStack(m.callStack),
+		Cause:       cause,
+	}
+}
+
+// RollBack puts the game back to how it was before the last input was read, which lets a player
+// carry on after a runtime error. Returns false if there is nothing to go back to
+func (m *Machine) RollBack() bool {
+	state := m.popUndo()
+	if state == nil {
+		return false
+	}
+
+	m.ReplaceState(state)
+	m.exitCode = 0
+
+	return true
+}
+
+// Error policies, how recoverable errors are dealt with. The spec recommends these four levels
+// See: https://zspec.jaredreisinger.com/A-errors#error-reporting-levels
+const (
+	ERRORS_IGNORE = 0 // Never report the error, just recover
+	ERRORS_ONCE   = 1 // Report the first error of each kind
+	ERRORS_ALWAYS = 2 // Report every error
+	ERRORS_FATAL  = 3 // Stop the game on any error
+)
+
+// ErrorKind is a type of error that the machine can recover from, as plenty of released games
+// contain harmless bugs such as using object 0. The recovery for each kind is given below
+type ErrorKind int
+
+const (
+	ERR_INVALID_OBJECT  ErrorKind = iota // Object 0 or past the end of the table, it acts as an empty object
+	ERR_INVALID_ATTR                     // Attribute out of range, it reads as clear and can't be set
+	ERR_INVALID_PROP                     // Missing or oversized property, writes are ignored and reads give the first word
+	ERR_DIVIDE_BY_ZERO                   // Division or modulus by zero, the result is 0
+	ERR_STACK_UNDERFLOW                  // Pop from an empty routine stack, gives 0
+	ERR_INVALID_ROUTINE                  // Call to something that's not a routine, which returns false
+	ERR_STREAM_NESTING                   // Output stream 3 selected too many times over, it's left as it was
+	ERR_INVALID_FRAME                    // Throw to a frame that isn't on the call stack, it's ignored
+)
+
+var errorKindNames = []string{
+	"invalid object",
+	"invalid attribute",
+	"invalid property",
+	"division by zero",
+	"stack underflow",
+	"invalid routine",
+	"output stream 3 nested too deep",
+	"invalid frame",
+}
+
+func (k ErrorKind) String() string {
+	return errorKindNames[k]
+}
+
+// SetErrorPolicy picks how recoverable errors are dealt with, one of the ERRORS_* values
+func (m *Machine) SetErrorPolicy(policy int) {
+	m.errorPolicy = policy
+}
+
+// reportError deals with a recoverable error according to the error policy
+// It only returns if the caller should carry on with its recovery, a fatal policy panics
+func (m *Machine) reportError(kind ErrorKind, format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+
+	switch m.errorPolicy {
+	case ERRORS_IGNORE:
+		return
+	case ERRORS_FATAL:
+		panic(fmt.Errorf("%s: %s", kind, msg))
+	case ERRORS_ONCE:
+		if m.errorsSeen[kind] {
+			return
+		}
+	}
+
+	m.errorsSeen[kind] = true
+	m.ext.TextOut(fmt.Sprintf("\n[Warning: %s: %s (PC = %08X)]\n", kind, msg, m.pc))
+}
@@ -7,11 +7,33 @@
 
 package zmachine
 
+import (
+	"io"
+	"time"
+)
+
 // External defines the interface for external functions provided to the Z-machine
 type External interface {
 	TextOut(text string)
-	ReadInput() string
-	PlaySound(soundID uint16, effect uint16, volume uint16)
-	Save(state *SaveState) bool
-	Load(name string, m *Machine) bool
+	TranscriptOut(text string) // Output stream 2, a transcript of the game
+	CommandOut(command string) // Output stream 4, a record of the player's commands
+	CloseStream(stream int)    // Output stream 2 or 4 has been deselected, so anything held open can be closed
+	// Read a line of input, returns false if the timeout expires first, a timeout of 0 waits forever
+	// A read which times out is carried on by the next call, unless AbandonInput is called first
+	ReadInput(timeout time.Duration) (string, bool)
+	AbandonInput() // A timed read was given up by the game, anything typed for it is thrown away
+	// Read a single key press for read_char, special keys are given as KEY_* codes
+	ReadChar(timeout time.Duration) (rune, bool)
+	OpenScript(name string) io.Reader   // Input stream 1, a script of commands, nil if there isn't one
+	ShowStatus(status StatusLine)       // Draw the status line, only called for versions 1 to 3
+	ShowUpperWindow(lines [][]TextRun)  // Draw the upper window, no lines means the screen isn't split
+	ClearLowerWindow()                  // Erase all of the lower window
+	SetTextStyle(style int)             // Style for lower window text from now on, STYLE_* bits
+	SetColour(fg int, bg int)           // Colours for lower window text from now on, COLOUR_* values
+	PlaySound(sound Sound)              // Play a sound effect, stopping any that's already playing
+	StopSound()                         // Stop the sound effect that's playing
+	Bleep(high bool)                    // Make a high or low bleep, these need no sound data
+	Save(name string, data []byte) bool // Store a Quetzal save file
+	Load(name string) []byte            // Fetch a Quetzal save file, nil if there isn't one
+	Capabilities() Capabilities         // What the frontend can do, checked once at the start
 }
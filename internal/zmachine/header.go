@@ -0,0 +1,104 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// header.go - Header fields owned by the interpreter, telling the game what we can do
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+import "github.com/benc-uk/gozm/internal/decode"
+
+const (
+	INTERPRETER_NUMBER  = 6   // We claim to be the IBM PC interpreter, as many others do
+	INTERPRETER_VERSION = 'A' // Letters are used for the interpreter version in v4 & v5
+	STANDARD_MAJOR      = 1   // Revision of the standard we follow, 1.0
+	STANDARD_MINOR      = 0
+	SCREEN_HEIGHT       = 255 // Screen height in lines, 255 means infinite so the game never needs to page
+)
+
+// Capabilities describe what a frontend is able to do, which the game is told about in the header
+// Anything a frontend can't do the machine still copes with, the game is just asked not to use it
+type Capabilities struct {
+	StatusLine  bool // Can show the status line in v1-3
+	SplitScreen bool // Can show an upper window
+	Styles      bool // Bold, italic & reverse video text
+	FixedPitch  bool // A fixed pitch font, for the upper window and font 4
+	TimedInput  bool // Reads that time out
+	Colours     bool // Text colours, for the lower window
+	Sound       bool
+	Width       int // Screen width in characters, 0 for the default of SCREEN_WIDTH
+}
+
+// Bits of Flags 2 the game sets that the interpreter keeps, through a restore or restart
+// These are the transcript and the request for fixed pitch text
+// See: https://zspec.jaredreisinger.com/11-header#11_1_7_3
+const FLAGS2_PRESERVE = 0x03
+
+// writeHeader fills in the header fields the interpreter is responsible for, this is done at the
+// start and again whenever memory is replaced from a save or on a restart, as that wipes them
+// See: https://zspec.jaredreisinger.com/11-header
+func (m *Machine) writeHeader() {
+	caps := m.caps
+
+	// Flags 1, which has very different meanings before and after v4
+	flags1 := m.mem[0x01]
+	if m.version <= 3 {
+		flags1 = setBits(flags1, 0x10, !caps.StatusLine) // Bit 4 is set if there is no status line
+		flags1 = setBits(flags1, 0x20, caps.SplitScreen)
+		flags1 &^= 0x40 // Variable pitch font isn't the default
+	} else {
+		flags1 = setBits(flags1, 0x01, caps.Colours)
+		flags1 &^= 0x02 // No pictures
+		flags1 = setBits(flags1, 0x04, caps.Styles)
+		flags1 = setBits(flags1, 0x08, caps.Styles)
+		flags1 = setBits(flags1, 0x10, caps.FixedPitch)
+		flags1 = setBits(flags1, 0x20, caps.Sound && m.version == 6)
+		flags1 = setBits(flags1, 0x80, caps.TimedInput)
+	}
+	m.mem[0x01] = flags1
+
+	// The game asks for things in Flags 2 from v5, we clear the bits for things we can't do
+	// Bit 3 is pictures, 4 is undo, 5 is the mouse, 6 colours and 7 sound effects
+	if m.version >= 5 {
+		flags2 := m.mem[0x11]
+		flags2 &^= 0x08 | 0x20
+		flags2 = setBits(flags2, 0x40, caps.Colours && flags2&0x40 != 0)
+		flags2 = setBits(flags2, 0x80, caps.Sound && flags2&0x80 != 0)
+		m.mem[0x11] = flags2
+	}
+
+	// Interpreter number & version, the version is a number in v6 and a letter before that
+	if m.version >= 4 {
+		m.mem[0x1E] = INTERPRETER_NUMBER
+		m.mem[0x1F] = INTERPRETER_VERSION
+		if m.version == 6 {
+			m.mem[0x1F] = 1
+		}
+	}
+
+	// From v4 games can ask how big the screen is
+	if m.version >= 4 {
+		m.mem[0x20] = SCREEN_HEIGHT
+		m.mem[0x21] = byte(m.screen.width)
+	}
+
+	// From v5 the screen size is also given in units, we make a unit one character
+	if m.version >= 5 {
+		decode.SetWord(m.mem, 0x22, uint16(m.screen.width))
+		decode.SetWord(m.mem, 0x24, SCREEN_HEIGHT)
+		m.mem[0x26] = 1
+		m.mem[0x27] = 1
+	}
+
+	m.mem[0x32] = STANDARD_MAJOR
+	m.mem[0x33] = STANDARD_MINOR
+}
+
+// Sets or clears bits in a flags byte
+func setBits(flags byte, bits byte, set bool) byte {
+	if set {
+		return flags | bits
+	}
+	return flags &^ bits
+}
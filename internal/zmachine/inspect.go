@@ -0,0 +1,275 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// inspect.go - Dumping the header, objects, dictionary and other tables of a story
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/benc-uk/gozm/internal/decode"
+)
+
+// StoryDump is everything that can be found out about a story without running it
+type StoryDump struct {
+	Header        HeaderDump     `json:"header"`
+	Objects       []*ObjectView  `json:"objects"`
+	Dictionary    DictionaryDump `json:"dictionary"`
+	Abbreviations []string       `json:"abbreviations"`
+	PropDefaults  []uint16       `json:"prop_defaults"` // Default for each property, starting at property 1
+}
+
+// HeaderDump holds the header fields set by the compiler, as they are in the story file
+// See: https://zspec.jaredreisinger.com/11-header
+type HeaderDump struct {
+	Version          byte     `json:"version"`
+	Release          uint16   `json:"release"`
+	Serial           string   `json:"serial"`
+	Checksum         uint16   `json:"checksum"`
+	ChecksumValid    bool     `json:"checksum_valid"`
+	FileLength       uint32   `json:"file_length"`
+	Flags1           byte     `json:"flags1"`
+	Flags1Names      []string `json:"flags1_names"`
+	Flags2           uint16   `json:"flags2"`
+	Flags2Names      []string `json:"flags2_names"`
+	HighMemory       uint16   `json:"high_memory"`
+	InitialPC        uint16   `json:"initial_pc"`
+	Dictionary       uint16   `json:"dictionary"`
+	ObjectTable      uint16   `json:"object_table"`
+	Globals          uint16   `json:"globals"`
+	StaticMemory     uint16   `json:"static_memory"`
+	Abbreviations    uint16   `json:"abbreviations"`
+	RoutinesOffset   uint16   `json:"routines_offset,omitempty"`
+	StringsOffset    uint16   `json:"strings_offset,omitempty"`
+	TerminatingChars uint16   `json:"terminating_chars,omitempty"`
+	AlphabetTable    uint16   `json:"alphabet_table,omitempty"`
+	HeaderExtension  uint16   `json:"header_extension,omitempty"`
+	Standard         string   `json:"standard,omitempty"` // Revision of the standard the game wants, if it says
+	Compiler         string   `json:"compiler,omitempty"` // Inform puts its version at the end of the header
+}
+
+// DictionaryDump holds the game's main dictionary
+type DictionaryDump struct {
+	Separators  string      `json:"separators"`
+	EntryLength byte        `json:"entry_length"`
+	Sorted      bool        `json:"sorted"`
+	Entries     []DictEntry `json:"entries"`
+}
+
+// DictEntry is a single word in the dictionary, with the data the game stores after it
+type DictEntry struct {
+	Addr uint16   `json:"addr"`
+	Word string   `json:"word"`
+	Data HexBytes `json:"data"`
+}
+
+// HexBytes is data shown as hex in JSON, rather than the base64 used for []byte
+type HexBytes []byte
+
+func (b HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(b))
+}
+
+// Names of the bits in Flags 1, which changed meaning completely in v4
+var flags1Names = []string{"", "status line shows time", "two discs", "censored", "no status line",
+	"split screen", "variable pitch font", ""}
+var flags1NamesV4 = []string{"colours", "pictures", "bold", "italic", "fixed pitch font",
+	"sound", "", "timed input"}
+
+// Names of the bits in Flags 2
+var flags2Names = []string{"transcript", "fixed pitch", "redraw status", "pictures", "undo", "mouse",
+	"colours", "sound", "menus"}
+
+// Dump describes the story's header, objects, dictionary and tables. It's meant to be used on a
+// newly created machine, as the objects and dictionary are read from memory as they are now
+func (m *Machine) Dump() *StoryDump {
+	story := m.story
+	h := HeaderDump{
+		Version:       m.version,
+		Release:       decode.GetWord(story, 0x02),
+		Serial:        string(story[0x12:0x18]),
+		Checksum:      m.checksum,
+		ChecksumValid: m.validateChecksum(),
+		FileLength:    m.fileLen,
+		Flags1:        story[0x01],
+		Flags2:        decode.GetWord(story, 0x10),
+		HighMemory:    m.highAddr,
+		InitialPC:     m.initialPC,
+		Dictionary:    m.dictAddr,
+		ObjectTable:   m.objectsAddr,
+		Globals:       m.globalsAddr,
+		StaticMemory:  m.staticAddr,
+		Abbreviations: m.abbrvAddr,
+	}
+
+	names := flags1Names
+	if m.version >= 4 {
+		names = flags1NamesV4
+	}
+	for bit, name := range names {
+		if name != "" && h.Flags1&(1<<bit) != 0 {
+			h.Flags1Names = append(h.Flags1Names, name)
+		}
+	}
+	for bit, name := range flags2Names {
+		if h.Flags2&(1<<bit) != 0 {
+			h.Flags2Names = append(h.Flags2Names, name)
+		}
+	}
+
+	if m.version >= 5 {
+		h.TerminatingChars = decode.GetWord(story, 0x2E)
+		h.AlphabetTable = decode.GetWord(story, 0x34)
+		h.HeaderExtension = decode.GetWord(story, 0x36)
+	}
+	if m.version == 6 || m.version == 7 {
+		h.RoutinesOffset, h.StringsOffset = m.routineOff, m.stringOff
+	}
+	if story[0x32] != 0 || story[0x33] != 0 {
+		h.Standard = fmt.Sprintf("%d.%d", story[0x32], story[0x33])
+	}
+	if compiler := string(story[0x3C:0x40]); isPrintable(compiler) {
+		h.Compiler = compiler
+	}
+
+	d := &StoryDump{
+		Header:        h,
+		Objects:       make([]*ObjectView, 0, m.objectCount),
+		Abbreviations: m.text.Abbr,
+		PropDefaults:  make([]uint16, m.propDefaultCount()),
+	}
+
+	for i := uint16(1); i <= m.objectCount; i++ {
+		d.Objects = append(d.Objects, m.getObject(i).view())
+	}
+
+	for i := range d.PropDefaults {
+		d.PropDefaults[i] = m.propDefault(byte(i + 1))
+	}
+
+	// Each dictionary entry is the encoded word followed by data bytes the game uses
+	dict := m.dict
+	entryLen := m.mem[dict.addr+1+uint16(len(dict.sep))]
+	d.Dictionary = DictionaryDump{
+		Separators:  m.text.ZSCIIString(dict.sep),
+		EntryLength: entryLen,
+		Sorted:      dict.sorted,
+		Entries:     make([]DictEntry, len(dict.entries)),
+	}
+	for i, e := range dict.entries {
+		d.Dictionary.Entries[i] = DictEntry{Addr: e.address, Word: m.text.String(e.key)}
+		if keyLen := uint16(len(e.key) * 2); uint16(entryLen) > keyLen {
+			d.Dictionary.Entries[i].Data = m.mem[e.address+keyLen : e.address+uint16(entryLen)]
+		}
+	}
+
+	return d
+}
+
+// Checks text only holds printable ASCII, so random bytes aren't taken to be text
+func isPrintable(s string) bool {
+	for _, c := range s {
+		if c < 32 || c > 126 {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// WriteText writes the dump as plain text, in the same sections as the JSON
+func (d *StoryDump) WriteText(w io.Writer) {
+	h := d.Header
+	fmt.Fprintf(w, "HEADER\n\n")
+	fmt.Fprintf(w, "Version:              %d\n", h.Version)
+	fmt.Fprintf(w, "Release:              %d\n", h.Release)
+	fmt.Fprintf(w, "Serial:               %s\n", h.Serial)
+	fmt.Fprintf(w, "Checksum:             %04X (valid: %t)\n", h.Checksum, h.ChecksumValid)
+	fmt.Fprintf(w, "File length:          %d\n", h.FileLength)
+	fmt.Fprintf(w, "Flags 1:              %s\n", strings.TrimSpace(fmt.Sprintf("%02X %s", h.Flags1, strings.Join(h.Flags1Names, ", "))))
+	fmt.Fprintf(w, "Flags 2:              %s\n", strings.TrimSpace(fmt.Sprintf("%04X %s", h.Flags2, strings.Join(h.Flags2Names, ", "))))
+	fmt.Fprintf(w, "High memory:          %04X\n", h.HighMemory)
+	fmt.Fprintf(w, "Initial PC:           %04X\n", h.InitialPC)
+	fmt.Fprintf(w, "Dictionary:           %04X\n", h.Dictionary)
+	fmt.Fprintf(w, "Object table:         %04X\n", h.ObjectTable)
+	fmt.Fprintf(w, "Globals:              %04X\n", h.Globals)
+	fmt.Fprintf(w, "Static memory:        %04X\n", h.StaticMemory)
+	fmt.Fprintf(w, "Abbreviations:        %04X\n", h.Abbreviations)
+	if h.Version == 6 || h.Version == 7 {
+		fmt.Fprintf(w, "Routines offset:      %04X\n", h.RoutinesOffset)
+		fmt.Fprintf(w, "Strings offset:       %04X\n", h.StringsOffset)
+	}
+	if h.Version >= 5 {
+		fmt.Fprintf(w, "Terminating chars:    %04X\n", h.TerminatingChars)
+		fmt.Fprintf(w, "Alphabet table:       %04X\n", h.AlphabetTable)
+		fmt.Fprintf(w, "Header extension:     %04X\n", h.HeaderExtension)
+	}
+	if h.Standard != "" {
+		fmt.Fprintf(w, "Standard:             %s\n", h.Standard)
+	}
+	if h.Compiler != "" {
+		fmt.Fprintf(w, "Compiler:             %s\n", h.Compiler)
+	}
+
+	// Objects are listed in full, then as a tree starting from the ones without a parent
+	fmt.Fprintf(w, "\nOBJECTS (%d)\n", len(d.Objects))
+	for _, o := range d.Objects {
+		attrs := []string{}
+		for i, set := range o.Attrs {
+			if set {
+				attrs = append(attrs, fmt.Sprint(i))
+			}
+		}
+
+		fmt.Fprintf(w, "\n%d. %q\n", o.Num, o.Desc)
+		fmt.Fprintf(w, "    Parent:%d Sibling:%d Child:%d\n", o.Parent, o.Sibling, o.Child)
+		if len(attrs) > 0 {
+			fmt.Fprintf(w, "    Attributes: %s\n", strings.Join(attrs, " "))
+		}
+		fmt.Fprint(w, o.propDebugDump())
+	}
+
+	fmt.Fprintf(w, "\nOBJECT TREE\n\n")
+	seen := map[uint16]bool{}
+	for _, o := range d.Objects {
+		if o.Parent == NULL_OBJECT {
+			d.writeTree(w, o, 0, seen)
+		}
+	}
+
+	fmt.Fprintf(w, "\nDICTIONARY (%d)\n\n", len(d.Dictionary.Entries))
+	fmt.Fprintf(w, "Separators: %q, entry length: %d, sorted: %t\n\n",
+		d.Dictionary.Separators, d.Dictionary.EntryLength, d.Dictionary.Sorted)
+	for i, e := range d.Dictionary.Entries {
+		fmt.Fprintf(w, "%4d %04X %-10s % X\n", i+1, e.Addr, e.Word, []byte(e.Data))
+	}
+
+	fmt.Fprintf(w, "\nABBREVIATIONS (%d)\n\n", len(d.Abbreviations))
+	for i, a := range d.Abbreviations {
+		fmt.Fprintf(w, "%2d %q\n", i, a)
+	}
+
+	fmt.Fprintf(w, "\nPROPERTY DEFAULTS\n\n")
+	for i, v := range d.PropDefaults {
+		fmt.Fprintf(w, "%2d %04X\n", i+1, v)
+	}
+}
+
+// Writes an object and everything inside it, indented by how deep it is in the tree
+// Objects already written are skipped, so a broken tree with loops in it can't go on forever
+func (d *StoryDump) writeTree(w io.Writer, o *ObjectView, depth int, seen map[uint16]bool) {
+	seen[o.Num] = true
+	fmt.Fprintf(w, "%s[%d] %s\n", strings.Repeat("  ", depth), o.Num, o.Desc)
+
+	for child := o.Child; child != NULL_OBJECT && int(child) <= len(d.Objects) && !seen[child]; {
+		c := d.Objects[child-1]
+		d.writeTree(w, c, depth+1, seen)
+		child = c.Sibling
+	}
+}
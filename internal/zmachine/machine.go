@@ -8,10 +8,13 @@
 package zmachine
 
 import (
+	"bufio"
 	"fmt"
-	"math/rand/v2"
+	"io"
 	"strings"
+	"time"
 
+	"github.com/benc-uk/gozm/internal/blorb"
 	"github.com/benc-uk/gozm/internal/decode"
 )
 
@@ -21,35 +24,76 @@ const (
 	DEBUG_STEP               = 1
 	DEBUG_TRACE              = 2
 	OUTPUT_STREAM_SCREEN     = 1
-	OUTPUT_STREAM_FILE       = 2
+	OUTPUT_STREAM_TRANSCRIPT = 2
 	OUTPUT_STREAM_MEMORY     = 3
+	OUTPUT_STREAM_COMMANDS   = 4
 	OUTPUT_STREAM_MEMORY_MAX = 16
-	INPUT_STREAM_KEYBOARD    = 1
-	INPUT_STREAM_FILE        = 2
+	INPUT_STREAM_KEYBOARD    = 0
+	INPUT_STREAM_FILE        = 1
 	EXIT_QUIT                = 1
-	EXIT_ERROR               = 2 // TODO: Not yet used
-	EXIT_RESTART             = 3
+	EXIT_ERROR               = 2
 	SYSTEM_CMD_PREFIX        = '/' // Prefix for system commands in input
+	UNDO_LEVELS              = 32  // Number of turns that can be undone
+	WINDOW_LOWER             = 0
+	WINDOW_UPPER             = 1
+	SCREEN_WIDTH             = 80 // Width of the screen in characters, unless the frontend says otherwise
+	STYLE_ROMAN              = 0
+	STYLE_REVERSE            = 1
+	STYLE_BOLD               = 2
+	STYLE_ITALIC             = 4
+	STYLE_FIXED              = 8
+	FONT_NORMAL              = 1 // Fonts for set_font, we have no picture font or character graphics
+	FONT_FIXED               = 4
+	COLOUR_CURRENT           = 0 // Colours for set_colour, 0 keeps the colour as it is
+	COLOUR_DEFAULT           = 1 // The frontend's own colour
+	COLOUR_BLACK             = 2
+	COLOUR_RED               = 3
+	COLOUR_GREEN             = 4
+	COLOUR_YELLOW            = 5
+	COLOUR_BLUE              = 6
+	COLOUR_MAGENTA           = 7
+	COLOUR_CYAN              = 8
+	COLOUR_WHITE             = 9
+	KEY_DELETE               = 8 // Special keys returned by ReadChar, as ZSCII codes
+	KEY_NEWLINE              = 13
+	KEY_ESCAPE               = 27
+	KEY_UP                   = 129
+	KEY_DOWN                 = 130
+	KEY_LEFT                 = 131
+	KEY_RIGHT                = 132
 )
 
 // Machine represents the state of a Z-machine interpreter
 type Machine struct {
-	name          string      // Name of the loaded Z-machine file
-	mem           []byte      // Z-machine memory
-	pc            uint32      // Program counter, supports 32-bit addressing for larger files
-	callStack     []CallFrame // Call stack of routines
-	debugLevel    int         // Debug verbosity level
-	propDefaults  []uint16    // Property defaults table
-	objects       []*zObject  // Objects table
-	rand          *rand.Rand  // Random number generator
-	outputStream  int         // Current output stream
-	inputStream   int         // Current input stream
-	abbr          []string    // Abbreviation table
-	dict          []dictEntry // Dictionary e	ntries
-	dictSep       []string    // Dictionary separator characters
-	dictStartAddr uint16      // Start address of dictionary entries
-	exitCode      int         // Flag to indicate machine termination
-	ext           External    // External interface for I/O
+	name            string         // Name of the loaded Z-machine file
+	mem             []byte         // Z-machine memory
+	story           []byte         // Original story file bytes, never modified
+	pc              uint32         // Program counter, supports 32-bit addressing for larger files
+	callStack       []CallFrame    // Call stack of routines
+	debugLevel      int            // Debug verbosity level
+	debugger        *debugger      // Interactive debugger, nil unless it has been started
+	objectCount     uint16         // Number of objects in the object table
+	rand            *randomGen     // Random number generator
+	streams         outputStreams  // Selected output streams
+	inputStream     int            // Current input stream
+	script          *bufio.Scanner // Command script being read when the input stream is a file
+	queuedInput     []string       // Lines to be read before the keyboard, see QueueInput
+	scriptReader    io.Reader      // Source of the command script, closed when we're done
+	text            decode.Decoder // Decodes & encodes text, a v5+ game can supply its own alphabets
+	dict            *dictionary    // The game's main dictionary
+	exitCode        int            // Flag to indicate machine termination
+	stateReplaced   bool           // Set when a restore replaces state mid-instruction
+	started         bool           // Set once Run has been called, it can be called again after an error
+	caps            Capabilities   // What the frontend can do
+	blorb           *blorb.Blorb   // Blorb file the story came from, nil for a plain story file
+	sound           soundManager   // The sound effect channel
+	errorPolicy     int            // How recoverable errors are dealt with, one of the ERRORS_* values
+	errorsSeen      map[ErrorKind]bool
+	interruptResult uint16      // Value returned by the last interrupt routine
+	undo            undoRing    // Snapshots taken before each read, for undo
+	gameUndo        undoRing    // Snapshots taken by the game with save_undo
+	screen          screenModel // Upper & lower windows
+	ext             External    // External interface for I/O
 
 	version     byte   // Header: version number
 	highAddr    uint16 // Header: high memory address
@@ -57,15 +101,12 @@ type Machine struct {
 	dictAddr    uint16 // Header: dictionary table start address
 	objectsAddr uint16 // Header: objects table address
 	globalsAddr uint16 // Header: global variables table address
+	staticAddr  uint16 // Header: base of static memory, everything below is dynamic
 	abbrvAddr   uint16 // Header: abbreviation table address
-	fileLen     uint16 // Header: file length in words
+	routineOff  uint16 // Header: v6 & v7 routine offset, in units of 8 bytes
+	stringOff   uint16 // Header: v6 & v7 string offset, in units of 8 bytes
+	fileLen     uint32 // Header: file length, converted to bytes
 	checksum    uint16 // Header: checksum
-	flagStatus  bool   // Header: Is the status line shown? Nothing uses this it seems
-}
-
-type dictEntry struct {
-	word    string
-	address uint16
 }
 
 type SaveState struct {
@@ -73,22 +114,23 @@ type SaveState struct {
 	CallStack []CallFrame
 	Mem       []byte
 	Name      string
-	Objects   []*zObject
 }
 
 func NewMachine(data []byte, fileName string, debugLevel int, ext External) *Machine {
 	m := &Machine{
-		name:         fileName,
-		mem:          data,
-		pc:           uint32(decode.GetWord(data, 0x06)),
-		callStack:    make([]CallFrame, 0),
-		debugLevel:   debugLevel,
-		ext:          ext,
-		propDefaults: make([]uint16, 31),
-		objects:      make([]*zObject, 0),
-		rand:         rand.New(rand.NewPCG(123, 456)),
-		outputStream: OUTPUT_STREAM_SCREEN,
-		inputStream:  INPUT_STREAM_KEYBOARD,
+		name:        fileName,
+		mem:         append([]byte{}, data...),
+		story:       append([]byte{}, data...),
+		pc:          uint32(decode.GetWord(data, 0x06)),
+		callStack:   make([]CallFrame, 0),
+		debugLevel:  debugLevel,
+		ext:         ext,
+		rand:        newRandomGen(0),
+		streams:     outputStreams{screen: true},
+		screen:      screenModel{width: SCREEN_WIDTH, font: FONT_NORMAL, fg: COLOUR_DEFAULT, bg: COLOUR_DEFAULT},
+		inputStream: INPUT_STREAM_KEYBOARD,
+		errorPolicy: ERRORS_ONCE,
+		errorsSeen:  map[ErrorKind]bool{},
 
 		version:     data[0x00],
 		highAddr:    decode.GetWord(data, 0x04),
@@ -96,47 +138,29 @@ func NewMachine(data []byte, fileName string, debugLevel int, ext External) *Mac
 		dictAddr:    decode.GetWord(data, 0x08),
 		objectsAddr: decode.GetWord(data, 0x0A),
 		globalsAddr: decode.GetWord(data, 0x0C),
+		staticAddr:  decode.GetWord(data, 0x0E),
 		abbrvAddr:   decode.GetWord(data, 0x18),
-		fileLen:     decode.GetWord(data, 0x1A),
+		routineOff:  decode.GetWord(data, 0x28),
+		stringOff:   decode.GetWord(data, 0x2A),
+		fileLen:     decode.FileLength(data),
 		checksum:    decode.GetWord(data, 0x1C),
 	}
 
-	// Decode flag byte at 0x01, and status line flag is bit 4
-	m.flagStatus = (data[0x01] & 0x10) != 0
-
-	// Initialize abbreviations from the abbreviation table
-	m.abbr = make([]string, 96)
-	for i := uint16(0); i < 96; i++ {
-		// Abbreviation table contains word addresses, need to multiply by 2
-		// See: https://zspec.jaredreisinger.com/01-memory-map#1_2_2
-		abbrStringAddr := decode.GetWord(m.mem, m.abbrvAddr+i*2) * 2
-		s, _ := m.readStringLiteral(uint32(abbrStringAddr))
-		m.abbr[i] = s
+	// Tell the game what the frontend can do, and how big the screen is
+	m.caps = ext.Capabilities()
+	if m.caps.Width > 0 {
+		m.screen.width = min(m.caps.Width, 255)
 	}
+	m.writeHeader()
+
+	// Text depends on the alphabets, abbreviations & Unicode table, which games can customise
+	m.text = decode.NewDecoder(data)
 
-	// Initialize objects, property defaults table
+	// Initialize objects, these live in memory so this just counts them
 	m.initObjects()
 
 	// Dictionary initialization
-	numSepBytes := m.mem[m.dictAddr]
-	m.dictSep = make([]string, numSepBytes)
-	for i := byte(0); i < numSepBytes; i++ {
-		m.dictSep[i] = string(m.mem[m.dictAddr+1+uint16(i)])
-	}
-	entryLen := m.mem[m.dictAddr+1+uint16(numSepBytes)]
-	numEntries := decode.GetWord(m.mem, m.dictAddr+2+uint16(numSepBytes))
-
-	// Load dictionary entries
-	m.dict = make([]dictEntry, numEntries)
-	m.dictStartAddr = m.dictAddr + 2 + uint16(numSepBytes) + 2
-	for i := uint16(0); i < numEntries; i++ {
-		entryAddr := m.dictStartAddr + uint16(i)*uint16(entryLen)
-		s, _ := m.readStringLiteral(uint32(entryAddr))
-		m.dict[i] = dictEntry{
-			word:    s,
-			address: entryAddr,
-		}
-	}
+	m.dict = m.loadDictionary(m.dictAddr)
 
 	// Initialize the stack with the main__ call frame
 	m.addCallFrame()
@@ -144,32 +168,152 @@ func NewMachine(data []byte, fileName string, debugLevel int, ext External) *Mac
 	return m
 }
 
-// NewFromSaveState creates a new Machine from a saved state
+// ReplaceState mutates the machine to match a saved state
+// The saved memory may be the full memory or just the dynamic part of it
 func (m *Machine) ReplaceState(state *SaveState) bool {
-	// Mutate machine state from saved state
-	m.mem = state.Mem
+	// Some bits of Flags 2 and the interpreter's own header fields must survive the change
+	flags2 := m.mem[0x11] & FLAGS2_PRESERVE
+
+	copy(m.mem, state.Mem)
 	m.pc = state.PC
 	m.callStack = state.CallStack
-	m.objects = state.Objects
+
+	m.mem[0x11] = m.mem[0x11]&^FLAGS2_PRESERVE | flags2
+	m.writeHeader()
 
 	return true
 }
 
-// Run starts the main execution loop of the Z-machine
-func (m *Machine) Run() int {
+// Restore loads a saved game from outside of the normal RESTORE opcode
+func (m *Machine) Restore() bool {
+	return m.restoreGame()
+}
+
+// restart puts the game back to the very start, for the RESTART opcode & system command
+// Dynamic memory is reloaded from the original story, but some bits of Flags 2 are kept
+// See: https://zspec.jaredreisinger.com/06-game-state#6_1_3
+func (m *Machine) restart() {
+	m.ReplaceState(&SaveState{
+		PC:        uint32(m.initialPC),
+		CallStack: make([]CallFrame, 0),
+		Mem:       m.story[:m.staticAddr],
+		Name:      m.name,
+	})
+	m.addCallFrame()
+
+	// Nothing from the old game should carry over, other than the transcript
+	m.streams.memory = nil
+	m.undo = undoRing{}
+	m.gameUndo = undoRing{}
+	m.eraseWindow(-1)
+	m.setWindow(WINDOW_LOWER)
+	m.setTextStyle(STYLE_ROMAN)
+	m.setColour(COLOUR_DEFAULT, COLOUR_DEFAULT)
+	if m.sound.playing != 0 {
+		m.ext.StopSound()
+		m.sound.playing = 0
+	}
+
+	// If this happened during a read, it has to be abandoned
+	m.stateReplaced = true
+}
+
+// saveGame encodes the current state as Quetzal and hands it to the frontend to store
+func (m *Machine) saveGame(pc uint32) bool {
+	data := m.encodeQuetzal(pc)
+	return m.ext.Save(m.name, data)
+}
+
+// restoreGame fetches a Quetzal save from the frontend and replaces the machine state
+// On success the PC is left wherever execution should continue from
+func (m *Machine) restoreGame() bool {
+	data := m.ext.Load(m.name)
+	if data == nil {
+		return false
+	}
+
+	state, resume, err := m.decodeQuetzal(data)
+	if err != nil {
+		m.print(fmt.Sprintf("Unable to restore: %s\n", err))
+		return false
+	}
+
+	m.ReplaceState(state)
+
+	// Snapshots from before the restore belong to a different game, so can't be undone to
+	m.undo = undoRing{}
+	m.gameUndo = undoRing{}
+
+	// A file made by the SAVE opcode carries on as if that SAVE had just succeeded
+	// From v4 SAVE stores a result, 2 means we've arrived here from a restore
+	if !resume {
+		if m.version > 3 {
+			m.storeVar(uint16(m.mem[m.pc]), 2)
+			m.pc++
+		} else {
+			m.branchHandler(0, true)
+		}
+	}
+
+	m.stateReplaced = true
+	return true
+}
+
+// Run starts the main execution loop of the Z-machine, it returns the exit code when the game
+// ends, or EXIT_ERROR and a *RuntimeError if the game hits a problem it can't carry on from
+func (m *Machine) Run() (exitCode int, err error) {
 	m.debug("Starting the main execution loop...\n")
 
+	defer func() {
+		if r := recover(); r != nil {
+			rerr, ok := r.(*RuntimeError)
+			if !ok {
+				panic(r) // Not one of ours, step always wraps what it catches
+			}
+			exitCode, err = EXIT_ERROR, rerr
+		}
+	}()
+
+	// A bad checksum could just be a patched game, so it's only a warning, given when play starts
+	// The earliest games have no checksum at all, so there's nothing to check
+	if !m.started {
+		m.started = true
+		if m.checksum != 0 && !m.validateChecksum() {
+			m.ext.TextOut(fmt.Sprintf("[Warning: story file checksum is %04X, the header says %04X, the file may be corrupt]\n",
+				m.calcChecksum(), m.checksum))
+		}
+	}
+
 	// We just loop forever for now, this is our life
 	for {
 		m.step()
 
 		// Check for exit condition there's been a request to terminate
 		if m.exitCode != 0 {
-			return m.exitCode
+			return m.exitCode, nil
 		}
 	}
 }
 
+// Unpacks the address of a routine, as given to the call opcodes
+// In v6 & v7 routines are offset by a value from the header, so they can be above 256K
+func (m *Machine) unpackRoutine(packed uint16) uint32 {
+	addr := decode.PackedAddress(packed, m.version)
+	if (m.version == 6 || m.version == 7) && packed != 0 {
+		addr += uint32(m.routineOff) * 8
+	}
+	return addr
+}
+
+// Unpacks the address of a string, as given to print_paddr
+func (m *Machine) unpackString(packed uint16) uint32 {
+	addr := decode.PackedAddress(packed, m.version)
+	if m.version == 6 || m.version == 7 {
+		addr += uint32(m.stringOff) * 8
+	}
+	return addr
+}
+
 // storeVar stores a value into a variable location
 func (m *Machine) storeVar(loc uint16, val uint16) {
 	// We made loc uint16 for ease of use, now restrict to valid range
@@ -199,7 +343,7 @@ func (m *Machine) getVar(loc uint16) uint16 {
 
 	if loc == 0 {
 		// Stack variable
-		return m.getCallFrame().Pop()
+		return m.popStack()
 	} else if loc > 0 && loc < 0x10 {
 		// Local variable
 		return m.getCallFrame().Locals[loc-1]
@@ -255,22 +399,10 @@ func (m *Machine) addToVar(loc uint16, val int16) int16 {
 	}
 }
 
-// Read a Z-machine string literal: 2byte pairs from the current PC
-// Returns the decoded string and number of words read
+// Read a Z-machine string literal, returning the decoded string and number of words read
 // Note: This takes a uint32 address to allow for strings in high memory
 func (m *Machine) readStringLiteral(addr uint32) (string, int) {
-	words := []uint16{}
-	for i := uint32(0); int(i) < len(m.mem); i += 2 {
-		word := decode.GetWord32(m.mem, addr+i)
-		words = append(words, word)
-
-		// If the high bit is set, this is the end of the string
-		if word&0x8000 != 0 {
-			break
-		}
-	}
-
-	return decode.String(words, m.abbr), len(words)
+	return m.text.StringAt(m.mem, addr)
 }
 
 // This is a complex helper used by all branch instructions
@@ -322,110 +454,112 @@ func (m *Machine) branchHandler(instLen uint16, condition bool) {
 	}
 }
 
-// validateChecksum computes and validates the checksum of the loaded Z-machine file
+// validateChecksum checks the original story file against the checksum in the header
 func (m *Machine) validateChecksum() bool {
+	return m.calcChecksum() == m.checksum
+}
+
+// calcChecksum sums every byte of the original story file after the header, up to the file
+// length given in the header, which can be shorter than the file as they are often padded
+// See: https://zspec.jaredreisinger.com/15-opcodes#verify
+func (m *Machine) calcChecksum() uint16 {
+	// Some early games have no file length in the header, so the whole file is used
+	length := int(m.fileLen)
+	if length == 0 || length > len(m.story) {
+		length = len(m.story)
+	}
+
 	checksum := uint16(0)
-	// Calculate checksum from byte 0x40 (64) onwards to end of file
-	for i := 0x40; i < len(m.mem); i++ {
-		checksum = (checksum + uint16(m.mem[i])&0xFFFF)
+	for i := 0x40; i < length; i++ {
+		checksum += uint16(m.story[i]) // Sum is modulo 0x10000, so wrapping is fine
 	}
-	return checksum == m.checksum
+	return checksum
 }
 
+// Sends text to all of the selected output streams
 func (m *Machine) print(s string) {
-	if m.outputStream == OUTPUT_STREAM_SCREEN {
-		m.ext.TextOut(s)
-	} else if m.outputStream == OUTPUT_STREAM_MEMORY {
-		// NOT IMPLEMENTED
+	// While stream 3 is selected it gets everything and no other stream sees any text
+	if len(m.streams.memory) > 0 {
+		m.writeMemoryStream(s)
+		return
 	}
-}
 
-// Wrapper to read input based on current input stream
-func (m *Machine) readString() string {
-	if m.inputStream == INPUT_STREAM_KEYBOARD {
-		input := m.ext.ReadInput()
-
-		// Handle system commands which start SYSTEM_CMD_PREFIX
-		if len(input) > 0 && input[0] == SYSTEM_CMD_PREFIX {
-			cmd := strings.TrimSpace(input[1:])
-			m.debug("System command received: %s\n", cmd)
-			switch strings.ToLower(cmd) {
-			case "quit", "exit":
-				m.exitCode = EXIT_QUIT
-			case "restart":
-				m.exitCode = EXIT_RESTART
-			case "save":
-				ok := m.ext.Save(m.GetSaveState())
-				if ok {
-					m.print("Game saved successfully.\n")
-				} else {
-					m.print("Failed to save game.\n")
-				}
-				return ""
-			case "load":
-				ok := m.ext.Load(m.name, m)
-				if ok {
-					m.print("Game loaded successfully.\n")
-				} else {
-					m.print("Failed to load game.\n")
-				}
-				return ""
-			case "info":
-				info := m.GetInfo()
-				m.print(info)
-			default:
-				m.debug(" - Unknown system command: %s\n", cmd)
-			}
+	// The upper window is only ever on screen, it's never part of the transcript
+	if m.screen.window == WINDOW_UPPER {
+		if m.streams.screen {
+			m.writeUpperWindow(s)
 		}
+		return
+	}
 
-		return input
-	} else if m.inputStream == INPUT_STREAM_FILE {
-		panic("NOT_IMPLEMENTED: input stream from file")
+	if m.streams.screen {
+		m.ext.TextOut(s)
 	}
 
-	return ""
+	if m.transcriptOn() {
+		m.ext.TranscriptOut(s)
+	}
 }
 
-// lookupWordInDict searches the dictionary for a word and returns its address
-// Returns a dictEntry with address 0 if the word is not found
-// If multiple words match, returns the longest matching word
-// See: https://zspec.jaredreisinger.com/13-dictionary
-func (m *Machine) lookupWordInDict(word string) dictEntry {
-	longestMatch := dictEntry{address: 0}
-
-	// Normalize the input word to lowercase for comparison
-	word = strings.ToLower(word)
-
-	for _, entry := range m.dict {
-		entryWord := strings.ToLower(entry.word)
-
-		// Dictionary entries are truncated (e.g., "leafle" for "leaflet" in v3)
-		// Check if the input word starts with the dictionary entry
-		if strings.HasPrefix(word, entryWord) {
-			// Keep the longest match
-			if len(entryWord) > len(longestMatch.word) {
-				longestMatch = entry
-			}
-		}
+// Wrapper to read input based on current input stream
+// Returns false if the timeout expired, see readLine
+func (m *Machine) readString(timeout time.Duration) (string, bool) {
+	input, ok := m.readLine(timeout)
+	if !ok {
+		return "", false
 	}
 
-	return longestMatch
-}
+	// Handle system commands which start SYSTEM_CMD_PREFIX
+	if len(input) > 0 && input[0] == SYSTEM_CMD_PREFIX {
+		cmd := strings.TrimSpace(input[1:])
+		m.debug("System command received: %s\n", cmd)
+		switch strings.ToLower(cmd) {
+		case "quit", "exit":
+			m.exitCode = EXIT_QUIT
+		case "restart":
+			m.restart()
+			return "", true
+		case "save":
+			// The game is given its own save command, so the file is written by its SAVE opcode
+			// in the standard form, which other interpreters can load
+			m.echoCommand("save")
+			return "save", true
+		case "load":
+			ok := m.Restore()
+			if ok {
+				m.print("Game loaded successfully.\n")
+			} else {
+				m.print("Failed to load game.\n")
+			}
+			return "", true
+		case "undo":
+			if m.Undo() {
+				m.print("Previous turn undone.\n")
+			} else {
+				m.print("Nothing to undo.\n")
+			}
+			return "", true
+		case "info":
+			info := m.GetInfo()
+			m.print(info)
+		case "debug":
+			m.StartDebugger()
+			return "", true
+		default:
+			m.debug(" - Unknown system command: %s\n", cmd)
+		}
 
-// A mostly unfinished show status line function
-// Almost no Z-machine games use this, so I'm leaving it unfinished
-func (m *Machine) showStatus() {
-	if !m.flagStatus {
-		return
+		return input, true
 	}
-	m.print("\033[2J") // Clear screen
 
-	score := m.getVar(17)  // global variable 17 is score
-	turns := m.getVar(18)  // global variable 16 is turns
-	objNum := m.getVar(16) // global variable 1 is the current object
+	m.echoCommand(input)
+	return input, true
+}
 
-	obj := m.getObject(byte(objNum))
-	m.print(fmt.Sprintf("\n\033[32m\033[7m %s                             score:%d turns:%d \033[27m\033[0m\n", obj.Desc, score, turns))
+// QueueInput gives lines to be read as if they had been typed, before going back to the keyboard
+// System commands can be queued too, e.g. to have the game save itself and then quit
+func (m *Machine) QueueInput(lines ...string) {
+	m.queuedInput = append(m.queuedInput, lines...)
 }
 
 func (m *Machine) RequestExit(code int) {
@@ -437,13 +571,13 @@ func (m *Machine) GetName() string {
 }
 
 // GetSaveState creates a SaveState snapshot of the current machine
+// Everything is copied, so the snapshot is unaffected as the machine carries on
 func (m *Machine) GetSaveState() *SaveState {
 	return &SaveState{
 		PC:        m.pc,
-		CallStack: m.callStack,
-		Mem:       m.mem,
+		CallStack: copyCallStack(m.callStack),
+		Mem:       append([]byte{}, m.mem[:m.staticAddr]...),
 		Name:      m.name,
-		Objects:   m.objects,
 	}
 }
 
@@ -452,11 +586,21 @@ func (m *Machine) GetInfo() string {
 	r += fmt.Sprintf("\nFile: %s\n", m.name)
 	r += fmt.Sprintf("Version: %d\n", m.version)
 	r += fmt.Sprintf("Memory size: %d bytes\n", len(m.mem))
+	r += fmt.Sprintf("File length: %d bytes\n", m.fileLen)
 	r += fmt.Sprintf("PC: %08X\n", m.pc)
 	r += fmt.Sprintf("Call stack depth: %d\n", len(m.callStack))
-	r += fmt.Sprintf("Objects: %d\n", len(m.objects))
-	r += fmt.Sprintf("Dictionary entries: %d\n", len(m.dict))
+	r += fmt.Sprintf("Objects: %d\n", m.objectCount)
+	r += fmt.Sprintf("Dictionary entries: %d\n", len(m.dict.entries))
 	r += fmt.Sprintf("High memory: %04X\n", m.highAddr)
 	r += fmt.Sprintf("Checksum: %04X (valid: %t)\n", m.checksum, m.validateChecksum())
+	if m.blorb != nil {
+		r += fmt.Sprintf("Blorb title: %s\n", m.blorb.Title())
+		r += fmt.Sprintf("Blorb resources: %d\n", len(m.blorb.Resources))
+	}
 	return r
 }
+
+// SetBlorb gives the machine the Blorb file the story was loaded from, for its resources
+func (m *Machine) SetBlorb(b *blorb.Blorb) {
+	m.blorb = b
+}
@@ -1,257 +1,457 @@
 // =======================================================================
 // Package: zmachine - Core Z-machine interpreter
-// objects.go - Object table parsing and object management
+// objects.go - Object table access and object management
 //
 // Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
 // =======================================================================
 
 package zmachine
 
-import (
-	"fmt"
-
-	"github.com/benc-uk/gozm/internal/decode"
-)
+import "github.com/benc-uk/gozm/internal/decode"
 
 const (
 	NULL_OBJECT = 0
 )
 
+// zObject is a handle onto a single entry in the object table
+// It holds no state of its own, every read and write goes straight to Z-machine memory
+// so games that poke the object or property tables directly always see the same data
+// See: https://zspec.jaredreisinger.com/12-objects
 type zObject struct {
-	Num     byte               `json:"num"`
-	Desc    string             `json:"desc"`
-	Attrs   [32]bool           `json:"attrs"`
-	Parent  byte               `json:"parent"`
-	Sibling byte               `json:"sibling"`
-	Child   byte               `json:"child"`
-	Props   []*property        `json:"props"`
-	PropMap map[byte]*property `json:"prop_map"`
+	m    *Machine
+	Num  uint16
+	addr uint16 // Address of this object's entry in the object table
+}
+
+// Property is a parsed view of a single property, used for debugging only
+type Property struct {
+	Num  byte     `json:"num"`
+	Size byte     `json:"size"`
+	Data HexBytes `json:"data"`
+	Addr uint16   `json:"addr"` // address in memory where this property data is stored
 }
 
-type property struct {
-	Num  byte   `json:"num"`
-	Size byte   `json:"size"`
-	Data []byte `json:"data"`
-	Addr uint16 `json:"addr"` // address in memory where this property is stored
+// ObjectView is a parsed snapshot of an object, it is never written back to memory
+// and exists purely so objects can be dumped and inspected when debugging
+type ObjectView struct {
+	Num     uint16      `json:"num"`
+	Desc    string      `json:"desc"`
+	Attrs   []bool      `json:"attrs"`
+	Parent  uint16      `json:"parent"`
+	Sibling uint16      `json:"sibling"`
+	Child   uint16      `json:"child"`
+	Props   []*Property `json:"props"`
 }
 
-// Parses the object table and initializes the objects in the machine
-// This is called once during machine initialization
+// Scans the object table to work out how many objects there are
+// This is called once during machine initialization, the table itself is never copied
 func (m *Machine) initObjects() {
-	if len(m.objects) > 0 || m.objectsAddr == 0 {
-		return // Already initialized or we have no object table
+	if m.objectsAddr == 0 {
+		return // We have no object table
 	}
 
-	// Parse property defaults table, don't think it's used much
-	for i := 0; i < 31; i++ {
-		propAddr := m.objectsAddr + uint16(i*2)
-		val := decode.GetWord(m.mem, propAddr)
-		m.propDefaults[i] = val
+	// The number of objects isn't stored anywhere, so walk the entries until we reach
+	// the lowest property table address seen, as the tables follow the object entries
+	// See: https://zspec.jaredreisinger.com/12-objects#remarks
+	entrySize, propOffset, maxObjects := uint16(9), uint16(7), uint16(255)
+	if m.version > 3 {
+		entrySize, propOffset, maxObjects = 14, 12, 0xFFFF
 	}
 
-	objTableAddr := m.objectsAddr + 62 // skip 31 properties * 2 bytes each
-	objCount := 0
-	propTableAddr := uint16(0xffff) // will be set when first object is read
+	objTableAddr := m.objectTableAddr()
+	lowestPropAddr := uint16(0xffff)
+	objCount := uint16(0)
 	for {
-		objEntryAddr := objTableAddr + uint16(objCount*9)
-
-		// Stop if we've reached the property table
-		if objEntryAddr >= propTableAddr {
+		objEntryAddr := objTableAddr + objCount*entrySize
+		if objEntryAddr >= lowestPropAddr || int(objEntryAddr)+int(entrySize) > len(m.mem) {
 			break
 		}
 
-		// Parse object entry
-		objCount++
-		obj := &zObject{
-			Num:     byte(objCount),
-			Props:   make([]*property, 0), // max 64 properties
-			PropMap: make(map[byte]*property),
+		propAddr := decode.GetWord(m.mem, objEntryAddr+propOffset)
+		if propAddr < lowestPropAddr {
+			lowestPropAddr = propAddr
 		}
 
-		// Read attributes bit at a time (4 bytes, 32 bits total)
-		for i := 0; i < 4; i++ {
-			attrByte := m.mem[objEntryAddr+uint16(i)]
-			for bit := 0; bit < 8; bit++ {
-				attrIndex := i*8 + bit
-				obj.Attrs[attrIndex] = (attrByte & (1 << (7 - bit))) != 0
-			}
+		objCount++
+
+		// There are at most 255 objects in v1-3
+		if objCount >= maxObjects {
+			break
 		}
+	}
 
-		// Read parent, sibling, child (1 byte each)
-		obj.Parent = m.mem[objEntryAddr+4]
-		obj.Sibling = m.mem[objEntryAddr+5]
-		obj.Child = m.mem[objEntryAddr+6]
+	m.objectCount = objCount
 
-		// Read properties pointer (2 bytes)
-		propAddr := decode.GetWord(m.mem, objEntryAddr+7)
-		if objCount == 1 {
-			propTableAddr = propAddr
+	if m.debugLevel == DEBUG_TRACE {
+		for i := uint16(1); i <= m.objectCount; i++ {
+			v := m.getObject(i).view()
+			m.trace("Object '%s' (%d): parent=%d, sibling=%d, child=%d, attr=%v\n",
+				v.Desc, v.Num, v.Parent, v.Sibling, v.Child, v.Attrs)
+			m.trace("%s\n\n", v.propDebugDump())
 		}
+	}
+}
 
-		// Parse properties table for this object
+// Helper to get an object by its number, an invalid number is reported and gives nil
+// All the zObject methods accept a nil object, which acts like an object with nothing in it
+func (m *Machine) getObject(objNum uint16) *zObject {
+	if objNum == NULL_OBJECT || objNum > m.objectCount {
+		m.reportError(ERR_INVALID_OBJECT, "attempt to access object %d", objNum)
+		return nil
+	}
 
-		// Property table header starts with a description: [ size byte | string literal (size * 2 bytes) ]
-		currPropAddr := propAddr
-		descSize := m.mem[currPropAddr]
-		descWords := make([]uint16, descSize)
-		for i := uint16(0); i < uint16(descSize); i++ {
-			word := decode.GetWord(m.mem, currPropAddr+1+i*2)
-			descWords[i] = word
-		}
-		obj.Desc = decode.String(descWords, m.abbr) // decode string literal
-		currPropAddr += 1 + uint16(descSize)*2
+	entrySize := uint16(9)
+	if m.version > 3 {
+		entrySize = 14
+	}
 
-		// Now read properties until we hit a 0 size byte in the header
-		for {
-			propHeader := m.mem[currPropAddr]
+	return &zObject{
+		m:    m,
+		Num:  objNum,
+		addr: m.objectTableAddr() + (objNum-1)*entrySize,
+	}
+}
 
-			// End of properties for this object
-			if propHeader == 0 {
-				break
-			}
+// The object entries follow the property defaults table, 31 words in v1-3 and 63 words after
+func (m *Machine) objectTableAddr() uint16 {
+	return m.objectsAddr + uint16(m.propDefaultCount())*2
+}
 
-			// Property number is in the lower 5 bits, size in upper 3 bits
-			propNum, propSize := decode.PropSizeNumber(propHeader) // Alternative way to get propNum and propSize
-			propData := make([]byte, propSize)
-			for i := byte(0); i < propSize; i++ {
-				propData[i] = m.mem[currPropAddr+1+uint16(i)]
-			}
+func (m *Machine) propDefaultCount() byte {
+	if m.version > 3 {
+		return 63
+	}
+	return 31
+}
 
-			prop := &property{
-				Num:  propNum,
-				Size: propSize,
-				Data: propData,
-				Addr: currPropAddr + 1, // Point to data not header
-			}
-			obj.Props = append(obj.Props, prop)
-			obj.PropMap[propNum] = prop
+// Number of attributes each object has, 32 in v1-3 and 48 after
+func (m *Machine) attrCount() byte {
+	if m.version > 3 {
+		return 48
+	}
+	return 32
+}
 
-			currPropAddr += 1 + uint16(propSize)
-		}
+// Get the default value of a property from the property defaults table
+func (m *Machine) propDefault(propNum byte) uint16 {
+	if propNum == 0 || propNum > m.propDefaultCount() {
+		return 0
+	}
 
-		// Debug output
-		m.trace("Parsed object '%s' (%d): parent=%d, sibling=%d, child=%d, props=%04x, attr=%v\n",
-			obj.Desc, obj.Num, obj.Parent, obj.Sibling, obj.Child, propAddr, obj.Attrs)
-		m.trace("%s\n\n", obj.propDebugDump())
+	return decode.GetWord(m.mem, m.objectsAddr+uint16(propNum-1)*2)
+}
 
-		// We assume objects are in order by number
-		m.objects = append(m.objects, obj)
+// The tree links are bytes in v1-3, with the attributes taking 4 bytes before them
+// From v4 they are words, after 6 bytes of attributes
+func (o *zObject) getLink(index uint16) uint16 {
+	if o == nil {
+		return NULL_OBJECT
+	}
+	if o.m.version > 3 {
+		return decode.GetWord(o.m.mem, o.addr+6+index*2)
+	}
+	return uint16(o.m.mem[o.addr+4+index])
+}
 
-		// Sanity check to prevent infinite loop
-		if objCount > 255 {
-			break
-		}
+func (o *zObject) setLink(index uint16, num uint16) {
+	if o == nil {
+		return
 	}
+	if o.m.version > 3 {
+		decode.SetWord(o.m.mem, o.addr+6+index*2, num)
+		return
+	}
+	o.m.mem[o.addr+4+index] = byte(num)
+}
+
+func (o *zObject) parent() uint16 {
+	return o.getLink(0)
+}
+
+func (o *zObject) sibling() uint16 {
+	return o.getLink(1)
+}
+
+func (o *zObject) child() uint16 {
+	return o.getLink(2)
 }
 
-// Helper to get an object by its number
-func (m *Machine) getObject(objNum byte) *zObject {
-	if objNum == NULL_OBJECT || int(objNum) > len(m.objects) {
-		panic(fmt.Sprintf("FATAL: Attempt to access object %d", objNum))
+func (o *zObject) setParent(num uint16) {
+	o.setLink(0, num)
+}
+
+func (o *zObject) setSibling(num uint16) {
+	o.setLink(1, num)
+}
+
+func (o *zObject) setChild(num uint16) {
+	o.setLink(2, num)
+}
+
+// Address of the property table for this object, which starts with the short name
+func (o *zObject) propTableAddr() uint16 {
+	if o.m.version > 3 {
+		return decode.GetWord(o.m.mem, o.addr+12)
+	}
+	return decode.GetWord(o.m.mem, o.addr+7)
+}
+
+// Decodes the short name of the object from the property table header
+func (o *zObject) desc() string {
+	if o == nil {
+		return ""
+	}
+	tableAddr := o.propTableAddr()
+	descSize := o.m.mem[tableAddr]
+	descWords := make([]uint16, descSize)
+	for i := uint16(0); i < uint16(descSize); i++ {
+		descWords[i] = decode.GetWord(o.m.mem, tableAddr+1+i*2)
 	}
 
-	return m.objects[objNum-1]
+	return o.m.text.String(descWords)
 }
 
+// Attributes are stored topmost bit first, attribute 0 is bit 7 of the first byte
 func (o *zObject) hasAttribute(attrNum byte) bool {
-	if attrNum > 31 {
+	if o == nil {
+		return false
+	}
+	if attrNum >= o.m.attrCount() {
+		o.m.reportError(ERR_INVALID_ATTR, "test of attribute %d on object %d", attrNum, o.Num)
 		return false
 	}
 
-	return o.Attrs[attrNum]
+	attrByte := o.m.mem[o.addr+uint16(attrNum/8)]
+	return attrByte&(0x80>>(attrNum%8)) != 0
 }
 
 func (o *zObject) setAttribute(attrNum byte, value bool) {
-	if attrNum > 31 {
+	if o == nil {
+		return
+	}
+	if attrNum >= o.m.attrCount() {
+		o.m.reportError(ERR_INVALID_ATTR, "change of attribute %d on object %d", attrNum, o.Num)
 		return
 	}
 
-	o.Attrs[attrNum] = value
+	addr := o.addr + uint16(attrNum/8)
+	mask := byte(0x80 >> (attrNum % 8))
+	if value {
+		o.m.mem[addr] |= mask
+	} else {
+		o.m.mem[addr] &^= mask
+	}
 }
 
-func (o *zObject) removeObjectFromParent(m *Machine) {
-	if o.Parent == NULL_OBJECT {
-		return // No parent to remove from
+// Address of the first property size byte, skipping the short name in the header
+func (o *zObject) firstPropAddr() uint16 {
+	tableAddr := o.propTableAddr()
+	return tableAddr + 1 + uint16(o.m.mem[tableAddr])*2
+}
+
+// Decodes the size byte(s) of the property at addr, which differ between versions
+// Returns the property number, data size and the number of size bytes
+func (m *Machine) propHeader(addr uint16) (byte, byte, uint16) {
+	if m.version > 3 {
+		return decode.PropSizeNumberV4(m.mem[addr], m.mem[addr+1])
+	}
+
+	num, size := decode.PropSizeNumber(m.mem[addr])
+	return num, size, 1
+}
+
+// Works out the size of a property from the address of its data, as used by get_prop_len
+// In v4+ the byte before the data is either the only size byte or the second of two
+func (m *Machine) propLenFromData(dataAddr uint16) byte {
+	sizeByte := m.mem[dataAddr-1]
+	if m.version <= 3 {
+		_, size := decode.PropSizeNumber(sizeByte)
+		return size
+	}
+
+	if sizeByte&0x80 != 0 {
+		size := sizeByte & 0x3F
+		if size == 0 {
+			size = 64
+		}
+		return size
+	}
+
+	if sizeByte&0x40 != 0 {
+		return 2
+	}
+	return 1
+}
+
+// Walks the property list in memory and returns the data address and size of a property
+// The address points at the property data, not the size byte, or is 0 if not found
+func (o *zObject) findProp(propNum byte) (uint16, byte) {
+	if o == nil {
+		return 0, 0
 	}
+	addr := o.firstPropAddr()
+	for {
+		if o.m.mem[addr] == 0 {
+			return 0, 0 // End of property list
+		}
+
+		num, size, headerLen := o.m.propHeader(addr)
+		if num == propNum {
+			return addr + headerLen, size
+		}
+
+		// Properties are in descending order, so we can stop early
+		if num < propNum {
+			return 0, 0
+		}
 
-	parentObj := m.getObject(o.Parent)
-	if parentObj == nil {
-		return // Parent object not found
+		addr += headerLen + uint16(size)
 	}
+}
+
+// Returns the number of the property following the given one, or the first property
+// when propNum is 0. Returns 0 when there are no more properties
+func (o *zObject) nextProp(propNum byte) byte {
+	if o == nil {
+		return 0
+	}
+	addr := o.firstPropAddr()
+	if propNum != 0 {
+		dataAddr, size := o.findProp(propNum)
+		if dataAddr == 0 {
+			return 0 // Illegal to ask for the next of a missing property
+		}
+		addr = dataAddr + uint16(size)
+	}
+
+	if o.m.mem[addr] == 0 {
+		return 0 // End of property list
+	}
+
+	num, _, _ := o.m.propHeader(addr)
+	return num
+}
+
+func (o *zObject) removeObjectFromParent() {
+	parentNum := o.parent()
+	if parentNum == NULL_OBJECT {
+		return // No parent to remove from
+	}
+
+	parentObj := o.m.getObject(parentNum)
 
 	// If this object is the first child of the parent
-	if parentObj.Child == o.Num {
-		parentObj.Child = o.Sibling
+	if parentObj.child() == o.Num {
+		parentObj.setChild(o.sibling())
 	} else {
 		// If this object is not the first child, find the previous sibling
-		siblingNum := parentObj.Child
+		siblingNum := parentObj.child()
 		for siblingNum != NULL_OBJECT {
-			siblingObj := m.getObject(siblingNum)
-			if siblingObj == nil {
-				break // Should not happen
-			}
-			if siblingObj.Sibling == o.Num {
+			siblingObj := o.m.getObject(siblingNum)
+			if siblingObj.sibling() == o.Num {
 				// Found the previous sibling, update its sibling pointer
-				siblingObj.Sibling = o.Sibling
+				siblingObj.setSibling(o.sibling())
 				break
 			}
-			siblingNum = siblingObj.Sibling
+			siblingNum = siblingObj.sibling()
 		}
 	}
 
 	// Clear this object's parent and sibling pointers
-	o.Parent = NULL_OBJECT
-	o.Sibling = NULL_OBJECT
+	o.setParent(NULL_OBJECT)
+	o.setSibling(NULL_OBJECT)
 }
 
-func (o *zObject) insertIntoParent(m *Machine, newParentNum byte) {
+func (o *zObject) insertIntoParent(newParentNum uint16) {
+	if o == nil {
+		return
+	}
+
 	// First remove from current parent if any
-	o.removeObjectFromParent(m)
+	o.removeObjectFromParent()
 
-	// Set new parent
-	o.Parent = newParentNum
-	newParentObj := m.getObject(newParentNum)
+	// Insert as first child of new parent, with no valid parent the object is just left removed
+	newParentObj := o.m.getObject(newParentNum)
 	if newParentObj == nil {
-		return // New parent object not found
+		return
 	}
-
-	// Insert as first child of new parent
-	o.Sibling = newParentObj.Child
-	newParentObj.Child = o.Num
+	o.setParent(newParentNum)
+	o.setSibling(newParentObj.child())
+	newParentObj.setChild(o.Num)
 }
 
-func (o *zObject) getPropertyValue(propNum byte, defaults []uint16) uint16 {
-	prop, exists := o.PropMap[propNum]
-	if !exists {
-		// Return default value if property not found
-		if int(propNum) < len(defaults) {
-			return defaults[propNum-1]
-		}
+func (o *zObject) getPropertyValue(propNum byte) uint16 {
+	if o == nil {
 		return 0
 	}
 
-	// Return property value as uint16 (assuming properties are at most 2 bytes)
-	if prop.Size == 1 {
-		return uint16(prop.Data[0])
-	} else if prop.Size == 2 {
-		return decode.GetWord(prop.Data, 0)
+	addr, size := o.findProp(propNum)
+	if addr == 0 {
+		// Return default value if property not found
+		return o.m.propDefault(propNum)
+	}
+
+	// Return property value as uint16, get_prop is only meant for properties of 1 or 2 bytes
+	if size == 1 {
+		return uint16(o.m.mem[addr])
+	} else if size > 2 {
+		o.m.reportError(ERR_INVALID_PROP, "get_prop of property %d on object %d, which has size %d", propNum, o.Num, size)
 	}
 
-	return 0 // Unsupported property size
+	return decode.GetWord(o.m.mem, addr)
 }
 
 func (o *zObject) setPropertyValue(propNum byte, value uint16) {
-	prop, exists := o.PropMap[propNum]
-	if !exists {
-		// Property does not exist, cannot set
+	if o == nil {
+		return
+	}
+
+	addr, size := o.findProp(propNum)
+	if addr == 0 {
+		o.m.reportError(ERR_INVALID_PROP, "put_prop of missing property %d on object %d", propNum, o.Num)
 		return
 	}
 
 	// Set property value (assuming properties are at most 2 bytes)
-	if prop.Size == 1 {
-		prop.Data[0] = byte(value & 0xFF)
-	} else if prop.Size == 2 {
-		prop.Data[0] = byte((value >> 8) & 0xFF)
-		prop.Data[1] = byte(value & 0xFF)
+	if size == 1 {
+		o.m.mem[addr] = byte(value & 0xFF)
+	} else if size == 2 {
+		decode.SetWord(o.m.mem, addr, value)
+	}
+}
+
+// Builds a parsed snapshot of the object from memory, for debugging
+func (o *zObject) view() *ObjectView {
+	v := &ObjectView{
+		Num:     o.Num,
+		Desc:    o.desc(),
+		Parent:  o.parent(),
+		Sibling: o.sibling(),
+		Child:   o.child(),
+		Attrs:   make([]bool, o.m.attrCount()),
+		Props:   make([]*Property, 0),
+	}
+
+	for i := range v.Attrs {
+		v.Attrs[i] = o.hasAttribute(byte(i))
 	}
+
+	addr := o.firstPropAddr()
+	for o.m.mem[addr] != 0 {
+		num, size, headerLen := o.m.propHeader(addr)
+		dataAddr := addr + headerLen
+		data := make([]byte, size)
+		copy(data, o.m.mem[dataAddr:dataAddr+uint16(size)])
+
+		v.Props = append(v.Props, &Property{
+			Num:  num,
+			Size: size,
+			Data: data,
+			Addr: dataAddr, // Point to data not header
+		})
+
+		addr = dataAddr + uint16(size)
+	}
+
+	return v
 }
@@ -0,0 +1,301 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// quetzal.go - Quetzal (IFF) save file encoding and decoding
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Quetzal is the standard portable save format shared by most Z-machine interpreters
+// It's an IFF file of type IFZS holding a set of chunks, we write IFhd, CMem & Stks
+// See: https://inform-fiction.org/zmachine/standards/quetzal/
+
+// IntD payload written by older versions of gozm, tells us the PC is an instruction to
+// re-execute rather than the branch/store byte of a SAVE. These saves can still be loaded
+const quetzalResumeInst = 0x01
+
+// encodeQuetzal serialises the current machine state as a Quetzal file
+// The pc should point at the branch or store byte of the SAVE instruction
+func (m *Machine) encodeQuetzal(pc uint32) []byte {
+	// IFhd: release, serial, checksum and the PC, 13 bytes
+	ifhd := make([]byte, 13)
+	copy(ifhd[0:2], m.story[0x02:0x04])
+	copy(ifhd[2:8], m.story[0x12:0x18])
+	copy(ifhd[8:10], m.story[0x1C:0x1E])
+	putUint24(ifhd[10:13], pc)
+
+	// CMem: dynamic memory XOR'ed against the original story then run length encoded
+	cmem := compressMem(m.mem[:m.staticAddr], m.story[:m.staticAddr])
+
+	// Stks: one entry per call frame, oldest first
+	stks := new(bytes.Buffer)
+	for i, frame := range m.callStack {
+		var retPC uint32
+		var storeVar byte
+		var flags byte
+		// The first frame is the dummy main frame which has no return address
+		if i > 0 && frame.Discard {
+			// Bit 4 of the flags marks a call with no store byte
+			retPC = frame.ReturnAddr
+			flags = 0x10
+		} else if i > 0 {
+			retPC = frame.ReturnAddr + 1
+			storeVar = m.mem[frame.ReturnAddr]
+		}
+
+		frameHeader := make([]byte, 8)
+		putUint24(frameHeader[0:3], retPC)
+		frameHeader[3] = frame.NumLocals&0x0F | flags
+		frameHeader[4] = storeVar
+		frameHeader[5] = byte(1<<frame.ArgCount) - 1 // One bit per argument supplied
+		binary.BigEndian.PutUint16(frameHeader[6:8], uint16(len(frame.Stack)))
+		stks.Write(frameHeader)
+
+		for l := byte(0); l < frame.NumLocals; l++ {
+			_ = binary.Write(stks, binary.BigEndian, frame.Locals[l])
+		}
+		for _, val := range frame.Stack {
+			_ = binary.Write(stks, binary.BigEndian, val)
+		}
+	}
+
+	body := new(bytes.Buffer)
+	body.WriteString("IFZS")
+	writeChunk(body, "IFhd", ifhd)
+	writeChunk(body, "CMem", cmem)
+	writeChunk(body, "Stks", stks.Bytes())
+
+	out := new(bytes.Buffer)
+	writeChunk(out, "FORM", body.Bytes())
+
+	return out.Bytes()
+}
+
+// decodeQuetzal parses a Quetzal file into a SaveState, checking it belongs to this story
+// Returns the state and true if the PC is an instruction to resume rather than a SAVE
+func (m *Machine) decodeQuetzal(data []byte) (*SaveState, bool, error) {
+	if len(data) < 12 || string(data[0:4]) != "FORM" || string(data[8:12]) != "IFZS" {
+		return nil, false, errors.New("not a Quetzal save file")
+	}
+
+	formLen := int(binary.BigEndian.Uint32(data[4:8]))
+	if formLen+8 > len(data) {
+		return nil, false, errors.New("truncated Quetzal save file")
+	}
+
+	state := &SaveState{
+		Name: m.name,
+	}
+	resume := false
+	gotHeader, gotMem, gotStack := false, false, false
+
+	// Walk the chunks, any we don't know about are skipped as the spec requires
+	offset := 12
+	for offset+8 <= formLen+8 {
+		id := string(data[offset : offset+4])
+		size := int(binary.BigEndian.Uint32(data[offset+4 : offset+8]))
+		start := offset + 8
+		if start+size > len(data) {
+			return nil, false, fmt.Errorf("chunk %s overruns end of file", id)
+		}
+		chunk := data[start : start+size]
+
+		switch id {
+		case "IFhd":
+			if size < 13 {
+				return nil, false, errors.New("IFhd chunk too short")
+			}
+			if !bytes.Equal(chunk[0:2], m.story[0x02:0x04]) || !bytes.Equal(chunk[2:8], m.story[0x12:0x18]) ||
+				!bytes.Equal(chunk[8:10], m.story[0x1C:0x1E]) {
+				return nil, false, errors.New("save file is for a different story")
+			}
+			state.PC = getUint24(chunk[10:13])
+			gotHeader = true
+
+		case "CMem":
+			mem, err := decompressMem(chunk, m.story[:m.staticAddr])
+			if err != nil {
+				return nil, false, err
+			}
+			state.Mem = mem
+			gotMem = true
+
+		case "UMem":
+			if size != int(m.staticAddr) {
+				return nil, false, errors.New("UMem chunk is the wrong size")
+			}
+			state.Mem = append([]byte{}, chunk...)
+			gotMem = true
+
+		case "Stks":
+			stack, err := decodeStacks(chunk)
+			if err != nil {
+				return nil, false, err
+			}
+			state.CallStack = stack
+			gotStack = true
+
+		case "IntD":
+			if size >= 13 && string(chunk[8:12]) == "GOZM" {
+				resume = chunk[12] == quetzalResumeInst
+			}
+		}
+
+		// Chunks are padded to an even length
+		offset = start + size + size%2
+	}
+
+	if !gotHeader || !gotMem || !gotStack {
+		return nil, false, errors.New("save file is missing required chunks")
+	}
+
+	return state, resume, nil
+}
+
+// Decodes the Stks chunk into call frames, with return addresses pointing at store bytes
+func decodeStacks(chunk []byte) ([]CallFrame, error) {
+	frames := make([]CallFrame, 0)
+	offset := 0
+	for offset < len(chunk) {
+		if offset+8 > len(chunk) {
+			return nil, errors.New("truncated Stks chunk")
+		}
+
+		retPC := getUint24(chunk[offset : offset+3])
+		numLocals := chunk[offset+3] & 0x0F
+		discard := chunk[offset+3]&0x10 != 0
+		argsMask := chunk[offset+5]
+		stackLen := int(binary.BigEndian.Uint16(chunk[offset+6 : offset+8]))
+		offset += 8
+
+		if offset+int(numLocals)*2+stackLen*2 > len(chunk) {
+			return nil, errors.New("truncated Stks chunk")
+		}
+
+		frame := CallFrame{
+			Locals:    make([]uint16, 15),
+			Stack:     make([]uint16, stackLen),
+			NumLocals: numLocals,
+			Discard:   discard,
+		}
+
+		// Our return address is the store byte, which is just before the Quetzal return PC
+		if retPC > 0 && !discard {
+			frame.ReturnAddr = retPC - 1
+		} else {
+			frame.ReturnAddr = retPC
+		}
+
+		// Arguments supplied are flagged one bit each, from bit 0 upwards
+		for argsMask&(1<<frame.ArgCount) != 0 && frame.ArgCount < 7 {
+			frame.ArgCount++
+		}
+
+		for l := 0; l < int(numLocals); l++ {
+			frame.Locals[l] = binary.BigEndian.Uint16(chunk[offset : offset+2])
+			offset += 2
+		}
+		for s := 0; s < stackLen; s++ {
+			frame.Stack[s] = binary.BigEndian.Uint16(chunk[offset : offset+2])
+			offset += 2
+		}
+
+		frames = append(frames, frame)
+	}
+
+	if len(frames) == 0 {
+		return nil, errors.New("no frames found in Stks chunk")
+	}
+
+	return frames, nil
+}
+
+// compressMem XORs memory against the original and run length encodes the result
+// A zero byte is followed by a count of how many more zeros follow it
+// Trailing zeros are dropped entirely, as the spec allows
+func compressMem(mem []byte, original []byte) []byte {
+	out := make([]byte, 0, 256)
+	zeroRun := 0
+
+	for i := range mem {
+		b := mem[i] ^ original[i]
+		if b == 0 {
+			zeroRun++
+			continue
+		}
+
+		out = appendZeroRun(out, zeroRun)
+		zeroRun = 0
+		out = append(out, b)
+	}
+
+	return out
+}
+
+// Writes a run of zeros as pairs of 0 and count-1, each pair covering up to 256 bytes
+func appendZeroRun(out []byte, run int) []byte {
+	for run > 0 {
+		n := min(run, 256)
+		out = append(out, 0, byte(n-1))
+		run -= n
+	}
+
+	return out
+}
+
+// decompressMem reverses compressMem, returning a fresh copy of memory
+func decompressMem(data []byte, original []byte) ([]byte, error) {
+	mem := append([]byte{}, original...)
+	pos := 0
+
+	for i := 0; i < len(data); i++ {
+		if data[i] == 0 {
+			if i+1 >= len(data) {
+				return nil, errors.New("CMem chunk ends in the middle of a run")
+			}
+			i++
+			pos += int(data[i]) + 1
+			continue
+		}
+
+		if pos >= len(mem) {
+			return nil, errors.New("CMem chunk is larger than dynamic memory")
+		}
+		mem[pos] ^= data[i]
+		pos++
+	}
+
+	if pos > len(mem) {
+		return nil, errors.New("CMem chunk is larger than dynamic memory")
+	}
+
+	return mem, nil
+}
+
+// Writes an IFF chunk, with a pad byte when the data has an odd length
+func writeChunk(buf *bytes.Buffer, id string, data []byte) {
+	buf.WriteString(id)
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
+
+func getUint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
@@ -0,0 +1,174 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// quetzal_test.go - Tests for Quetzal save files and the memory compression they use
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testExternal is a frontend that does nothing, for machines that are never run
+type testExternal struct{}
+
+func (testExternal) TextOut(text string)                            {}
+func (testExternal) TranscriptOut(text string)                      {}
+func (testExternal) CommandOut(command string)                      {}
+func (testExternal) CloseStream(stream int)                         {}
+func (testExternal) ReadInput(timeout time.Duration) (string, bool) { return "", true }
+func (testExternal) AbandonInput()                                  {}
+func (testExternal) ReadChar(timeout time.Duration) (rune, bool)    { return 0, true }
+func (testExternal) OpenScript(name string) io.Reader               { return nil }
+func (testExternal) ShowStatus(status StatusLine)                   {}
+func (testExternal) ShowUpperWindow(lines [][]TextRun)              {}
+func (testExternal) ClearLowerWindow()                              {}
+func (testExternal) SetTextStyle(style int)                         {}
+func (testExternal) SetColour(fg int, bg int)                       {}
+func (testExternal) PlaySound(sound Sound)                          {}
+func (testExternal) StopSound()                                     {}
+func (testExternal) Bleep(high bool)                                {}
+func (testExternal) Save(name string, data []byte) bool             { return true }
+func (testExternal) Load(name string) []byte                        { return nil }
+func (testExternal) Capabilities() Capabilities                     { return Capabilities{} }
+
+func newTestMachine(t *testing.T, path string) *Machine {
+	t.Helper()
+
+	story, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewMachine(story, "test", DEBUG_NONE, testExternal{})
+}
+
+func TestQuetzalRoundTrip(t *testing.T) {
+	m := newTestMachine(t, "../../test/basic.z3")
+
+	// Changes spread over dynamic memory, with long unchanged runs between them
+	for _, addr := range []uint16{0x40, 0x41, 0x200, m.staticAddr - 1} {
+		m.mem[addr] ^= 0x5A
+	}
+
+	// A routine that stores its result, called from main, then one that throws it away
+	m.getCallFrame().Stack = []uint16{0x1234}
+	m.callStack = append(m.callStack,
+		CallFrame{ReturnAddr: 0x4A0, Locals: make([]uint16, 15), NumLocals: 3, ArgCount: 2, Stack: []uint16{1, 2}},
+		CallFrame{ReturnAddr: 0x4B0, Locals: make([]uint16, 15), NumLocals: 1, Discard: true, Stack: []uint16{}},
+	)
+	m.mem[0x4A0] = 0x10 // Store byte for the first call, global 0
+	copy(m.callStack[1].Locals, []uint16{7, 8, 9})
+	m.callStack[2].Locals[0] = 0xFFFF
+
+	data := m.encodeQuetzal(0x4C0)
+	state, resume, err := m.decodeQuetzal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resume {
+		t.Error("save is marked as resuming an instruction")
+	}
+	if state.PC != 0x4C0 {
+		t.Errorf("PC is %05X, want 004C0", state.PC)
+	}
+	if !bytes.Equal(state.Mem, m.mem[:m.staticAddr]) {
+		t.Error("dynamic memory is different")
+	}
+
+	if len(state.CallStack) != len(m.callStack) {
+		t.Fatalf("%d frames, want %d", len(state.CallStack), len(m.callStack))
+	}
+	for i, want := range m.callStack {
+		got := state.CallStack[i]
+		if got.ReturnAddr != want.ReturnAddr || got.NumLocals != want.NumLocals ||
+			got.ArgCount != want.ArgCount || got.Discard != want.Discard {
+			t.Errorf("frame %d is %+v, want %+v", i, got, want)
+		}
+		if !slices.Equal(got.Locals[:got.NumLocals], want.Locals[:want.NumLocals]) {
+			t.Errorf("frame %d locals are %04X, want %04X", i, got.Locals[:got.NumLocals], want.Locals[:want.NumLocals])
+		}
+		if !slices.Equal(got.Stack, want.Stack) {
+			t.Errorf("frame %d stack is %04X, want %04X", i, got.Stack, want.Stack)
+		}
+	}
+}
+
+func TestQuetzalOtherStory(t *testing.T) {
+	data := newTestMachine(t, "../../test/basic.z3").encodeQuetzal(0)
+	other := newTestMachine(t, "../../test/core.z3")
+	other.story[0x12] ^= 0xFF // Same release, but a different serial
+
+	if _, _, err := other.decodeQuetzal(data); err == nil {
+		t.Error("save for another story was loaded")
+	}
+}
+
+func TestCompressMem(t *testing.T) {
+	original := make([]byte, 1024)
+	for i := range original {
+		original[i] = byte(i * 7)
+	}
+
+	tests := map[string]struct {
+		changes []int // Bytes which are changed from the original
+		maxLen  int   // Longest the compressed data should be
+	}{
+		"unchanged":       {nil, 0},
+		"first byte":      {[]int{0}, 1},
+		"last byte":       {[]int{1023}, 9},
+		"run of 256":      {[]int{0, 257}, 4},
+		"run of 257":      {[]int{0, 258}, 6},
+		"run over 512":    {[]int{10, 600}, 10},
+		"trailing zeros":  {[]int{0, 1}, 2},
+		"scattered bytes": {[]int{3, 4, 100, 500, 501, 1000}, 20},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			mem := slices.Clone(original)
+			for _, i := range tc.changes {
+				mem[i] ^= 0xFF
+			}
+
+			data := compressMem(mem, original)
+			if len(data) > tc.maxLen {
+				t.Errorf("compressed to %d bytes, want at most %d: % X", len(data), tc.maxLen, data)
+			}
+
+			got, err := decompressMem(data, original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, mem) {
+				t.Error("memory is different after decompressing")
+			}
+		})
+	}
+}
+
+func TestDecompressMemErrors(t *testing.T) {
+	original := make([]byte, 16)
+
+	tests := map[string][]byte{
+		"ends in a run": {1, 0},
+		"run too long":  {0, 16, 1},
+		"too many":      bytes.Repeat([]byte{1}, 17),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decompressMem(data, original); err == nil {
+				t.Error("decompressed without an error")
+			}
+		})
+	}
+}
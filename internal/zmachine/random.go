@@ -0,0 +1,62 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// random.go - Random number generator, with the random & predictable modes
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+import "math/rand/v2"
+
+// Seeds below this put the generator into its counting mode
+const PREDICTABLE_SEED_LIMIT = 1000
+
+// randomGen is the source of numbers for the random opcode. It starts truly random unless given
+// a seed, and a game can switch it to a predictable mode, which is handy for testing
+// See: https://zspec.jaredreisinger.com/02-numbers#2_4
+type randomGen struct {
+	source *rand.Rand
+	count  uint16 // Last number given in counting mode
+	limit  uint16 // Counting mode goes 1, 2, 3 up to this then starts again, 0 when not counting
+}
+
+// newRandomGen creates a generator from a seed, zero means seed from the system's entropy
+func newRandomGen(seed uint64) *randomGen {
+	if seed == 0 {
+		return &randomGen{source: rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))}
+	}
+	return &randomGen{source: rand.New(rand.NewPCG(seed, 0))}
+}
+
+// next gives a number from 1 up to and including max
+func (r *randomGen) next(max uint16) uint16 {
+	if r.limit > 0 {
+		r.count = r.count%r.limit + 1
+		return (r.count-1)%max + 1
+	}
+
+	return uint16(r.source.IntN(int(max))) + 1
+}
+
+// seed handles random with a zero or negative range. Zero goes back to being truly random,
+// a small seed counts upwards, and anything bigger seeds the generator to give a repeatable sequence
+// See: https://zspec.jaredreisinger.com/02-numbers#2_4_1
+func (r *randomGen) seed(seed uint16) {
+	r.count, r.limit = 0, 0
+
+	switch {
+	case seed == 0:
+		*r = *newRandomGen(0)
+	case seed < PREDICTABLE_SEED_LIMIT:
+		r.limit = seed
+	default:
+		r.source = rand.New(rand.NewPCG(uint64(seed), 0))
+	}
+}
+
+// SetRandomSeed seeds the random number generator so a game plays out the same every time,
+// as long as the same commands are given. Zero gives a truly random game
+func (m *Machine) SetRandomSeed(seed uint64) {
+	m.rand = newRandomGen(seed)
+}
@@ -0,0 +1,295 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// screen.go - Screen model, with a fixed upper window and a scrolling lower one
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+// screenModel tracks the two windows of the v3+ screen model
+// The lower window is the normal scrolling text, which is sent straight to the frontend,
+// the upper window is a fixed grid of characters which the game can write anywhere in.
+// We hold the grid and pass the whole thing to the frontend whenever it needs redrawing
+// See: https://zspec.jaredreisinger.com/08-screen
+type screenModel struct {
+	width     int      // Width of the upper window in characters
+	window    int      // The selected window, WINDOW_LOWER or WINDOW_UPPER
+	upper     [][]cell // Upper window grid, one row per line, empty when not split
+	cursorRow int      // Upper window cursor, zero based
+	cursorCol int
+	style     int // Current text style, a combination of the STYLE_* bits
+	fg        int // Current foreground & background colours, COLOUR_* values
+	bg        int
+	font      int  // Current font, FONT_NORMAL or FONT_FIXED
+	buffered  bool // Set by buffer_mode, the frontends do their own wrapping so this is only noted
+	dirty     bool // Set when the upper window has changed since the frontend last saw it
+}
+
+// cell is a single character in the upper window grid
+type cell struct {
+	char  rune
+	style int
+}
+
+// TextRun is a piece of text all in the same style, lines of the upper window are made of these
+type TextRun struct {
+	Text  string
+	Style int
+}
+
+var blankCell = cell{char: ' ', style: STYLE_ROMAN}
+
+// splitWindow handles the split_window opcode, zero lines unsplits the screen
+func (m *Machine) splitWindow(lines int) {
+	s := &m.screen
+	old := s.upper
+	s.upper = make([][]cell, lines)
+
+	for row := range s.upper {
+		s.upper[row] = blankRow(s.width)
+
+		// Only in version 3 is the upper window cleared by a split
+		if m.version > 3 && row < len(old) {
+			copy(s.upper[row], old[row])
+		}
+	}
+
+	if s.cursorRow >= lines {
+		s.cursorRow, s.cursorCol = 0, 0
+	}
+
+	if lines == 0 {
+		s.window = WINDOW_LOWER
+	}
+
+	s.dirty = true
+	m.flushUpperWindow()
+}
+
+// setWindow handles the set_window opcode, selecting where text is printed
+func (m *Machine) setWindow(window int) {
+	s := &m.screen
+
+	switch window {
+	case WINDOW_LOWER:
+		s.window = WINDOW_LOWER
+		m.flushUpperWindow()
+
+	case WINDOW_UPPER:
+		// Selecting the upper window always puts the cursor back at the top left
+		s.window = WINDOW_UPPER
+		s.cursorRow, s.cursorCol = 0, 0
+
+	default:
+		m.debug(" - Unknown window %d\n", window)
+	}
+}
+
+// eraseWindow handles the erase_window opcode
+// -1 unsplits the screen and clears everything, -2 clears everything but keeps the split
+func (m *Machine) eraseWindow(window int) {
+	s := &m.screen
+
+	switch window {
+	case WINDOW_LOWER:
+		m.ext.ClearLowerWindow()
+
+	case WINDOW_UPPER:
+		for row := range s.upper {
+			s.upper[row] = blankRow(s.width)
+		}
+		s.cursorRow, s.cursorCol = 0, 0
+		s.dirty = true
+
+	case -1:
+		m.splitWindow(0)
+		m.ext.ClearLowerWindow()
+
+	case -2:
+		m.eraseWindow(WINDOW_UPPER)
+		m.flushUpperWindow()
+		m.ext.ClearLowerWindow()
+
+	default:
+		m.debug(" - Unknown window %d\n", window)
+	}
+}
+
+// eraseLine handles erase_line, which only does anything in the upper window
+// A value of 1 blanks from the cursor to the end of the line
+func (m *Machine) eraseLine(value int) {
+	s := &m.screen
+	if value != 1 || s.window != WINDOW_UPPER || s.cursorRow >= len(s.upper) {
+		return
+	}
+
+	for col := s.cursorCol; col < s.width; col++ {
+		s.upper[s.cursorRow][col] = blankCell
+	}
+	s.dirty = true
+}
+
+// setCursor moves the upper window cursor, the line and column start at 1
+// The lower window cursor is left to the frontend, so moving it is ignored
+func (m *Machine) setCursor(line int, column int) {
+	s := &m.screen
+	if s.window != WINDOW_UPPER {
+		m.debug(" - set_cursor ignored in lower window\n")
+		return
+	}
+
+	s.cursorRow = max(line-1, 0)
+	s.cursorCol = max(column-1, 0)
+}
+
+// getCursor returns the upper window cursor as a line and column starting at 1
+func (m *Machine) getCursor() (int, int) {
+	s := &m.screen
+	if s.window != WINDOW_UPPER {
+		return 1, 1
+	}
+
+	return s.cursorRow + 1, s.cursorCol + 1
+}
+
+// setTextStyle handles set_text_style, roman turns off all styles, others are combined
+func (m *Machine) setTextStyle(style int) {
+	s := &m.screen
+	if style == STYLE_ROMAN {
+		s.style = STYLE_ROMAN
+	} else {
+		s.style |= style
+	}
+
+	m.ext.SetTextStyle(s.style)
+}
+
+// setColour handles set_colour, for the text which follows in the lower window
+// COLOUR_CURRENT leaves a colour as it is, anything past white isn't a colour we have
+// See: https://zspec.jaredreisinger.com/08-screen#8_3_1
+func (m *Machine) setColour(fg int, bg int) {
+	if !m.caps.Colours {
+		m.debug(" - set colour fg:%d bg:%d ignored, no colours\n", fg, bg)
+		return
+	}
+
+	s := &m.screen
+	if fg >= COLOUR_DEFAULT && fg <= COLOUR_WHITE {
+		s.fg = fg
+	}
+	if bg >= COLOUR_DEFAULT && bg <= COLOUR_WHITE {
+		s.bg = bg
+	}
+
+	m.ext.SetColour(s.fg, s.bg)
+}
+
+// setFont handles set_font, returning the previous font or 0 if the font isn't available
+// Font 0 asks for the current font without changing it
+func (m *Machine) setFont(font int) uint16 {
+	s := &m.screen
+	prev := s.font
+
+	switch font {
+	case 0:
+		return uint16(prev)
+	case FONT_NORMAL, FONT_FIXED:
+		s.font = font
+		return uint16(prev)
+	default:
+		m.debug(" - font %d not available\n", font)
+		return 0
+	}
+}
+
+// printTable handles print_table, printing a rectangle of text with each line
+// starting below the last, skip gives the bytes to jump at the end of each line
+// See: https://zspec.jaredreisinger.com/15-opcodes#print_table
+func (m *Machine) printTable(addr uint16, width uint16, height uint16, skip uint16) {
+	s := &m.screen
+	col := s.cursorCol + 1
+
+	for row := uint16(0); row < height; row++ {
+		if row > 0 {
+			if s.window == WINDOW_UPPER {
+				m.setCursor(s.cursorRow+2, col)
+			} else {
+				m.print("\n")
+			}
+		}
+
+		m.print(m.text.ZSCIIString(m.mem[addr : addr+width]))
+		addr += width + skip
+	}
+}
+
+// writeUpperWindow prints text into the upper window grid at the cursor
+// Text doesn't wrap or scroll here, anything off the right or the bottom is lost
+func (m *Machine) writeUpperWindow(text string) {
+	s := &m.screen
+
+	for _, r := range text {
+		if r == '\n' {
+			s.cursorRow++
+			s.cursorCol = 0
+			continue
+		}
+
+		if s.cursorRow < len(s.upper) && s.cursorCol < s.width {
+			s.upper[s.cursorRow][s.cursorCol] = cell{char: r, style: s.style}
+			s.cursorCol++
+		}
+	}
+
+	s.dirty = true
+}
+
+// flushUpperWindow sends the upper window to the frontend if it has changed
+// This is done when leaving the upper window and before input, rather than on every print
+func (m *Machine) flushUpperWindow() {
+	s := &m.screen
+	if !s.dirty {
+		return
+	}
+
+	lines := make([][]TextRun, len(s.upper))
+	for i, row := range s.upper {
+		lines[i] = rowToRuns(row)
+	}
+
+	m.ext.ShowUpperWindow(lines)
+	s.dirty = false
+}
+
+// Groups a row of cells into runs of the same style, dropping any plain trailing spaces
+func rowToRuns(row []cell) []TextRun {
+	end := len(row)
+	for end > 0 && row[end-1] == blankCell {
+		end--
+	}
+
+	runs := make([]TextRun, 0)
+	for i := 0; i < end; {
+		j := i
+		text := make([]rune, 0)
+		for j < end && row[j].style == row[i].style {
+			text = append(text, row[j].char)
+			j++
+		}
+
+		runs = append(runs, TextRun{Text: string(text), Style: row[i].style})
+		i = j
+	}
+
+	return runs
+}
+
+func blankRow(width int) []cell {
+	row := make([]cell, width)
+	for i := range row {
+		row[i] = blankCell
+	}
+
+	return row
+}
@@ -0,0 +1,125 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// sound.go - Sound effects, played from the sounds in a Blorb file
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+import "github.com/benc-uk/gozm/internal/blorb"
+
+// The effects a game can ask for with sound_effect
+// See: https://zspec.jaredreisinger.com/15-opcodes#sound_effect
+const (
+	SOUND_PREPARE = 1
+	SOUND_START   = 2
+	SOUND_STOP    = 3
+	SOUND_FINISH  = 4 // Finished with, so it can be unloaded
+
+	SOUND_BLEEP_HIGH = 1 // Sounds 1 & 2 are always bleeps, not real sounds
+	SOUND_BLEEP_LOW  = 2
+
+	SOUND_VOLUME_MAX = 8   // Volumes go from 1 to 8
+	SOUND_FOREVER    = 255 // Repeats of 255 means keep playing until stopped
+)
+
+// Sound is a sound effect for the frontend to play, with the audio data from the Blorb file
+type Sound struct {
+	Number  uint16
+	Format  string // Type of the audio data, AIFF, OGGV or MOD
+	Data    []byte // The audio, AIFF data is a whole IFF form including its header
+	Volume  int    // From 1 to SOUND_VOLUME_MAX
+	Repeats int    // Times to play, SOUND_FOREVER for until it's stopped
+}
+
+// soundManager tracks the sound channel, only one sound plays at a time and starting a new
+// one stops whatever is already playing
+// See: https://zspec.jaredreisinger.com/09-sound
+type soundManager struct {
+	playing  uint16            // Number of the sound playing, 0 when nothing is
+	prepared map[uint16]*Sound // Sounds loaded from the Blorb file, ready to play
+}
+
+// soundEffect handles the sound_effect opcode
+func (m *Machine) soundEffect(number uint16, effect uint16, volRepeats uint16) {
+	// Bleeps are all the frontend needs to do without a Blorb file, and ignore the effect
+	if number == SOUND_BLEEP_HIGH || number == SOUND_BLEEP_LOW || (number == 0 && effect == 0) {
+		m.ext.Bleep(number != SOUND_BLEEP_LOW)
+		return
+	}
+
+	s := &m.sound
+	switch effect {
+	case SOUND_PREPARE:
+		m.prepareSound(number)
+
+	case SOUND_START:
+		sound := m.prepareSound(number)
+		if sound == nil {
+			return
+		}
+
+		// The low byte is the volume, 255 being loudest, from v5 the high byte is repeats
+		// Before v5 the repeats are part of the sound, which Blorb holds in its Loop chunk
+		volume := int(volRepeats & 0xFF)
+		if volume < 1 || volume > SOUND_VOLUME_MAX {
+			volume = SOUND_VOLUME_MAX
+		}
+		repeats := 1
+		if m.version >= 5 && volRepeats>>8 != 0 {
+			repeats = int(volRepeats >> 8)
+		}
+		if loop, ok := m.blorb.Loops[uint32(number)]; ok && m.version < 5 {
+			repeats = int(loop)
+			if loop == 0 {
+				repeats = SOUND_FOREVER
+			}
+		}
+
+		sound.Volume, sound.Repeats = volume, repeats
+		s.playing = number
+		m.ext.PlaySound(*sound)
+
+	case SOUND_STOP:
+		// Stopping sound 0, or the sound that's playing, stops it
+		if s.playing != 0 && (number == 0 || number == s.playing) {
+			m.ext.StopSound()
+			s.playing = 0
+		}
+
+	case SOUND_FINISH:
+		if number == s.playing {
+			m.ext.StopSound()
+			s.playing = 0
+		}
+		delete(s.prepared, number)
+	}
+}
+
+// prepareSound loads a sound from the Blorb file, nil if there's no such sound
+func (m *Machine) prepareSound(number uint16) *Sound {
+	s := &m.sound
+	if sound, ok := s.prepared[number]; ok {
+		return sound
+	}
+
+	if m.blorb == nil {
+		m.debug(" - no Blorb file to play sound %d from\n", number)
+		return nil
+	}
+
+	res := m.blorb.Find(blorb.USAGE_SOUND, uint32(number))
+	if res == nil {
+		m.debug(" - sound %d isn't in the Blorb file\n", number)
+		return nil
+	}
+
+	if s.prepared == nil {
+		s.prepared = map[uint16]*Sound{}
+	}
+	sound := &Sound{Number: number, Format: res.Type, Data: res.Data}
+	s.prepared[number] = sound
+
+	return sound
+}
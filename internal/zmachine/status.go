@@ -0,0 +1,84 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// status.go - The v1-3 status line, computed here and drawn by the frontend
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+import (
+	"fmt"
+
+	"github.com/benc-uk/gozm/internal/decode"
+)
+
+// StatusLine is the contents of the status line in versions 1 to 3
+// Games either show a score and move count, or for time games such as Deadline,
+// the time of day. Flags 1 bit 1 tells us which kind of game we have, v1 & v2 only
+// have score games
+// See: https://zspec.jaredreisinger.com/08-screen#8_2
+type StatusLine struct {
+	Location string // Short name of the object in global 0, normally the current room
+	TimeGame bool   // When set Hours & Minutes are used, otherwise Score & Moves
+	Score    int16
+	Moves    int16
+	Hours    int16
+	Minutes  int16
+}
+
+// Summary is the right hand side of the status line, ready to display
+func (s StatusLine) Summary() string {
+	if !s.TimeGame {
+		return fmt.Sprintf("Score: %d  Moves: %d", s.Score, s.Moves)
+	}
+
+	// Games store a 24 hour clock, but Infocom showed it as am/pm
+	hour := s.Hours % 12
+	if hour == 0 {
+		hour = 12
+	}
+	suffix := "am"
+	if s.Hours >= 12 {
+		suffix = "pm"
+	}
+
+	return fmt.Sprintf("Time: %d:%02d %s", hour, s.Minutes, suffix)
+}
+
+// getStatus builds the status line from the first three globals
+func (m *Machine) getStatus() StatusLine {
+	status := StatusLine{
+		TimeGame: m.version == 3 && m.mem[0x01]&0x02 != 0,
+	}
+
+	// The location might not be set yet, or could be junk early on in a game
+	locNum := m.getGlobal(0)
+	if locNum != NULL_OBJECT && locNum <= m.objectCount {
+		status.Location = m.getObject(locNum).desc()
+	}
+
+	if status.TimeGame {
+		status.Hours = int16(m.getGlobal(1))
+		status.Minutes = int16(m.getGlobal(2))
+	} else {
+		status.Score = int16(m.getGlobal(1))
+		status.Moves = int16(m.getGlobal(2))
+	}
+
+	return status
+}
+
+// showStatus hands the status line to the frontend to draw, only versions 1 to 3 have one
+func (m *Machine) showStatus() {
+	if m.version > 3 {
+		return
+	}
+
+	m.ext.ShowStatus(m.getStatus())
+}
+
+// Reads a global variable directly, numbered from 0, unlike getVar which takes a variable number
+func (m *Machine) getGlobal(num uint16) uint16 {
+	return decode.GetWord(m.mem, m.globalsAddr+num*2)
+}
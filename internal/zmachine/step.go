@@ -9,32 +9,44 @@ package zmachine
 
 import (
 	"fmt"
-	"math/rand/v2"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/benc-uk/gozm/internal/decode"
 )
 
 func (m *Machine) step() {
-	inst := m.decodeInst()
+	var inst instruction
 
-	// Trap panic in case of errors and provide debugging info
+	// Trap panics and turn them into a RuntimeError, which Run returns
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Printf("💥 Runtime error at %08X: %s\n", m.pc, inst.String())
-			m.DumpMem(uint16(m.pc), 12)
-			// Print stack trace
-			fmt.Printf("Stack trace:\n")
-			for i := len(m.callStack) - 1; i >= 0; i-- {
-				frame := m.callStack[i]
-				fmt.Printf(" - Frame %d: return to %08X\n", i, frame.ReturnAddr)
+			rerr := m.runtimeError(r, inst)
+			if m.debugLevel > DEBUG_NONE {
+				m.DumpMem(rerr.PC, 12)
 			}
-			panic(r) // Re-panic to get full stack trace
+			panic(rerr)
 		}
 	}()
 
+	// The debugger gets to look at each instruction first, and may be told to quit
+	if m.debugger != nil {
+		m.debugger.check(m)
+		if m.exitCode != 0 {
+			return
+		}
+	}
+
+	inst = m.decodeInst()
+
 	m.debug("\n%08X: %s\n", m.pc, inst.String())
 
+	if inst.ext {
+		m.stepExtended(inst)
+		return
+	}
+
 	// HUGE switch to decode and execute instructions!
 	switch inst.code {
 	// ===================== 0OP INSTRUCTIONS =====================
@@ -69,25 +81,41 @@ func (m *Machine) step() {
 	// SAVE
 	case 0xB5:
 		m.debug("SAVE instruction encountered, saving game...\n")
-		ok := m.ext.Save(m.GetSaveState())
-		m.branchHandler(inst.len, ok)
+		// The saved PC points at our branch or store byte, so a restore carries on from here
+		ok := m.saveGame(m.pc + uint32(inst.len))
+		if m.version > 3 {
+			// From v4 the result is stored rather than branched on
+			dest := m.mem[m.pc+uint32(inst.len)]
+			m.storeVar(uint16(dest), boolToWord(ok))
+			m.pc += uint32(inst.len) + 1 // +1 for dest byte
+		} else {
+			m.branchHandler(inst.len, ok)
+		}
 
 	// RESTORE
 	case 0xB6:
 		m.debug("RESTORE instruction encountered, restarting to load saved game...\n")
-		ok := m.ext.Load(m.name, m)
-		m.branchHandler(inst.len, ok)
+		// On success the PC has already moved on to wherever the save was made
+		if !m.restoreGame() {
+			if m.version > 3 {
+				dest := m.mem[m.pc+uint32(inst.len)]
+				m.storeVar(uint16(dest), 0)
+				m.pc += uint32(inst.len) + 1 // +1 for dest byte
+			} else {
+				m.branchHandler(inst.len, false)
+			}
+		}
 
 	// RESTART
 	case 0xB7:
 		m.debug("RESTART instruction encountered, restarting...\n")
-		m.exitCode = EXIT_RESTART
+		m.restart()
 
 	// QUIT
 	case 0xBA:
 		m.debug("QUIT instruction encountered, exiting...\n")
 		if m.debugLevel > DEBUG_NONE {
-			m.DumpMem(m.globalsAddr, 24)
+			m.DumpMem(uint32(m.globalsAddr), 24)
 		}
 		m.exitCode = EXIT_QUIT
 
@@ -96,26 +124,38 @@ func (m *Machine) step() {
 		m.print("\n")
 		m.pc += uint32(inst.len)
 
-	// SHOW_STATUS
+	// SHOW_STATUS, only valid in v3, for any other version this is a nop
 	case 0xBC:
-		m.showStatus()
+		if m.version == 3 {
+			m.showStatus()
+		}
 		m.pc += uint32(inst.len)
 
 	// VERIFY
 	case 0xBD:
-		res := true //m.validateChecksum()
-		m.branchHandler(inst.len, res)
+		m.branchHandler(inst.len, m.validateChecksum())
 
 	// RET_POPPED
 	case 0xB8:
-		val := m.getCallFrame().Pop()
+		val := m.popStack()
 		m.returnFromCall(val)
 
-	// POP
+	// POP aka CATCH in v5, which stores the current frame for a later THROW
 	case 0xB9:
-		m.getCallFrame().Pop()
+		if m.version >= 5 {
+			dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
+			m.storeVar(uint16(dest), uint16(len(m.callStack)))
+			m.pc += uint32(inst.len) + 1 // +1 for dest byte
+			return
+		}
+
+		m.popStack()
 		m.pc += uint32(inst.len)
 
+	// PIRACY, interpreters are asked to be gullible and always branch
+	case 0xBF:
+		m.branchHandler(inst.len, true)
+
 	// ===================== 1OP INSTRUCTIONS =====================
 
 	// JZ
@@ -125,26 +165,26 @@ func (m *Machine) step() {
 
 	// GET_SIBLING
 	case 0x81, 0x91, 0xA1:
-		objNum := byte(inst.operands[0])
-		sibling := m.getObject(objNum).Sibling
+		objNum := inst.operands[0]
+		sibling := m.getObject(objNum).sibling()
 		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
-		m.storeVar(uint16(dest), uint16(sibling))
+		m.storeVar(uint16(dest), sibling)
 		m.branchHandler(inst.len+1, sibling != NULL_OBJECT)
 
 	// GET_CHILD
 	case 0x82, 0x92, 0xA2:
-		objNum := byte(inst.operands[0])
-		sibling := m.getObject(objNum).Child
+		objNum := inst.operands[0]
+		child := m.getObject(objNum).child()
 		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
-		m.storeVar(uint16(dest), uint16(sibling))
-		m.branchHandler(inst.len+1, sibling != NULL_OBJECT)
+		m.storeVar(uint16(dest), child)
+		m.branchHandler(inst.len+1, child != NULL_OBJECT)
 
 	// GET_PARENT
 	case 0x83, 0x93, 0xA3:
-		objNum := byte(inst.operands[0])
-		sibling := m.getObject(objNum).Parent
+		objNum := inst.operands[0]
+		parent := m.getObject(objNum).parent()
 		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
-		m.storeVar(uint16(dest), uint16(sibling))
+		m.storeVar(uint16(dest), parent)
 		m.pc += uint32(inst.len) + 1 // +1 for dest byte
 
 	// GET_PROP_LEN
@@ -156,8 +196,7 @@ func (m *Machine) step() {
 		} else {
 			// Gotcha: The property address points to the property data, not the size byte
 			// The size byte is immediately before the property data
-			sizeByte := m.mem[propAddr-1]
-			_, length = decode.PropSizeNumber(sizeByte)
+			length = m.propLenFromData(propAddr)
 		}
 		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
 		m.storeVar(uint16(dest), uint16(length))
@@ -183,17 +222,21 @@ func (m *Machine) step() {
 		m.print(str)
 		m.pc += uint32(inst.len)
 
+	// CALL_1S
+	case 0x88, 0x98, 0xA8:
+		m.callRoutine(inst.operands[0], nil, m.pc+uint32(inst.len), false)
+
 	// REMOVE_OBJ
 	case 0x89, 0x99, 0xA9:
-		objNum := byte(inst.operands[0])
-		m.getObject(objNum).removeObjectFromParent(m)
+		objNum := inst.operands[0]
+		m.getObject(objNum).removeObjectFromParent()
 		m.pc += uint32(inst.len)
 
 	// PRINT_OBJ
 	case 0x8A, 0x9A, 0xAA:
-		objNum := byte(inst.operands[0])
+		objNum := inst.operands[0]
 		obj := m.getObject(objNum)
-		m.print(obj.Desc)
+		m.print(obj.desc())
 		m.pc += uint32(inst.len)
 
 	// RET
@@ -209,7 +252,7 @@ func (m *Machine) step() {
 	// PRINT_PADDR
 	case 0x8D, 0x9D, 0xAD:
 		packedAddr := inst.operands[0]
-		addr := decode.PackedAddress(packedAddr)
+		addr := m.unpackString(packedAddr)
 		str, _ := m.readStringLiteral(addr)
 		m.print(str)
 		m.pc += uint32(inst.len)
@@ -228,8 +271,13 @@ func (m *Machine) step() {
 		m.storeVar(uint16(varLoc), actualVal)
 		m.pc += uint32(inst.len) + 1 // +1 for dest byte
 
-	// NOT (BITWISE)
+	// NOT (BITWISE) aka CALL_1N in v5, when NOT moves to VAR form
 	case 0x8F, 0x9F, 0xAF:
+		if m.version >= 5 {
+			m.callRoutine(inst.operands[0], nil, m.pc+uint32(inst.len), true)
+			return
+		}
+
 		v := inst.operands[0]
 		varLoc := m.mem[m.pc+uint32(inst.len)]
 		m.storeVar(uint16(varLoc), ^v)
@@ -277,10 +325,10 @@ func (m *Machine) step() {
 
 	// JIN
 	case 0x06, 0x26, 0x46, 0x66, 0xC6:
-		childObjNum := byte(inst.operands[0])
-		parentObjNum := byte(inst.operands[1])
+		childObjNum := inst.operands[0]
+		parentObjNum := inst.operands[1]
 		childObj := m.getObject(childObjNum)
-		m.branchHandler(inst.len, childObj.Parent == parentObjNum)
+		m.branchHandler(inst.len, childObj.parent() == parentObjNum)
 
 	// TEST
 	case 0x07, 0x27, 0x47, 0x67, 0xC7:
@@ -308,14 +356,14 @@ func (m *Machine) step() {
 
 	// TEST_ATTR
 	case 0x0A, 0x2A, 0x4A, 0x6A, 0xCA:
-		objNum := byte(inst.operands[0])
+		objNum := inst.operands[0]
 		attrNum := byte(inst.operands[1])
 		obj := m.getObject(objNum)
 		m.branchHandler(inst.len, obj.hasAttribute(attrNum))
 
 	// SET_ATTR
 	case 0x0B, 0x2B, 0x4B, 0x6B, 0xCB:
-		objNum := byte(inst.operands[0])
+		objNum := inst.operands[0]
 		attrNum := byte(inst.operands[1])
 		obj := m.getObject(objNum)
 		obj.setAttribute(attrNum, true)
@@ -323,7 +371,7 @@ func (m *Machine) step() {
 
 	// CLEAR_ATTR
 	case 0x0C, 0x2C, 0x4C, 0x6C, 0xCC:
-		objNum := byte(inst.operands[0])
+		objNum := inst.operands[0]
 		attrNum := byte(inst.operands[1])
 		obj := m.getObject(objNum)
 		obj.setAttribute(attrNum, false)
@@ -340,61 +388,39 @@ func (m *Machine) step() {
 
 	// INSERT_OBJ
 	case 0x0E, 0x2E, 0x4E, 0x6E, 0xCE:
-		objNum := byte(inst.operands[0])
-		destParentNum := byte(inst.operands[1])
+		objNum := inst.operands[0]
+		destParentNum := inst.operands[1]
 		obj := m.getObject(objNum)
-		obj.insertIntoParent(m, destParentNum)
+		obj.insertIntoParent(destParentNum)
 		m.pc += uint32(inst.len)
 
 	// GET_PROP
 	case 0x11, 0x31, 0x51, 0x71, 0xD1:
-		objNum := byte(inst.operands[0])
+		objNum := inst.operands[0]
 		propNum := byte(inst.operands[1])
 		obj := m.getObject(objNum)
-		val := obj.getPropertyValue(propNum, m.propDefaults)
+		val := obj.getPropertyValue(propNum)
 		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
 		m.storeVar(uint16(dest), val)
 		m.pc += uint32(inst.len) + 1 // +1 for dest byte
 
 	// GET_PROP_ADDR
 	case 0x12, 0x32, 0x52, 0x72, 0xD2:
-		objNum := byte(inst.operands[0])
+		objNum := inst.operands[0]
 		propNum := byte(inst.operands[1])
 		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
 		obj := m.getObject(objNum)
-		// Property address is address of property data, not header & it may not exist
-		prop, exist := obj.PropMap[propNum]
-		addr := uint16(0)
-		if exist {
-			addr = prop.Addr
-		}
-		m.storeVar(uint16(dest), uint16(addr))
+		// Property address is address of property data, not header & it's 0 if it doesn't exist
+		addr, _ := obj.findProp(propNum)
+		m.storeVar(uint16(dest), addr)
 		m.pc += uint32(inst.len) + 1 // +1 for dest byte
 
 	// GET_NEXT_PROP
 	case 0x13, 0x33, 0x53, 0x73, 0xD3:
-		objNum := byte(inst.operands[0])
+		objNum := inst.operands[0]
 		propNum := byte(inst.operands[1])
 		obj := m.getObject(objNum)
-		var nextPropNum byte
-
-		if propNum == 0 {
-			// Return first property number
-			if len(obj.Props) > 0 {
-				nextPropNum = obj.Props[0].Num
-			}
-		} else {
-			for i, prop := range obj.Props {
-				if prop.Num == propNum {
-					if i+1 < len(obj.Props) {
-						nextPropNum = obj.Props[i+1].Num
-					} else {
-						nextPropNum = 0
-					}
-					break
-				}
-			}
-		}
+		nextPropNum := obj.nextProp(propNum) // When propNum is 0, this is the first property
 
 		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
 		m.storeVar(uint16(dest), uint16(nextPropNum))
@@ -435,7 +461,10 @@ func (m *Machine) step() {
 		m.debug(" - div dest var:%d\n", dest)
 
 		if s == 0 {
-			panic("Division by zero!")
+			m.reportError(ERR_DIVIDE_BY_ZERO, "division of %d by zero", int16(v))
+			m.storeVar(uint16(dest), 0)
+			m.pc += uint32(inst.len) + 1 // +1 for dest byte
+			break
 		}
 
 		// NOTE: div should be signed division
@@ -450,7 +479,10 @@ func (m *Machine) step() {
 		m.debug(" - mod dest var:%d\n", dest)
 
 		if s == 0 {
-			panic("Division by zero!")
+			m.reportError(ERR_DIVIDE_BY_ZERO, "modulus of %d by zero", int16(v))
+			m.storeVar(uint16(dest), 0)
+			m.pc += uint32(inst.len) + 1 // +1 for dest byte
+			break
 		}
 
 		// NOTE: mod should be signed modulus
@@ -479,53 +511,37 @@ func (m *Machine) step() {
 		m.storeVar(uint16(dest), uint16(val))
 		m.pc += uint32(inst.len) + 1 // +1 for dest byte
 
-	// ===================== VAR INSTRUCTIONS =====================
+	// CALL_2S
+	case 0x19, 0x39, 0x59, 0x79, 0xD9:
+		m.callRoutine(inst.operands[0], inst.operands[1:], m.pc+uint32(inst.len), false)
 
-	// CALL
-	case 0xE0:
-		// Packed address supports 32-bit addressing for routines in high memory
-		routineAddr := decode.PackedAddress(inst.operands[0])
+	// CALL_2N
+	case 0x1A, 0x3A, 0x5A, 0x7A, 0xDA:
+		m.callRoutine(inst.operands[0], inst.operands[1:], m.pc+uint32(inst.len), true)
 
-		// When the address 0 is called as a routine, nothing happens and the return value is false
-		if routineAddr == 0 {
-			m.debug(" - call to NULL routine, returning false\n")
-			// Store byte is at PC + inst.len, store 0 (false) there
-			dest := m.mem[m.pc+uint32(inst.len)]
-			m.storeVar(uint16(dest), 0)
-			m.pc += uint32(inst.len) + 1 // +1 for store byte
-			return
-		}
+	// SET_COLOUR, the operands are signed as v6 uses -1 for the colour under the cursor
+	case 0x1B, 0x3B, 0x5B, 0x7B, 0xDB:
+		m.setColour(int(int16(inst.operands[0])), int(int16(inst.operands[1])))
+		m.pc += uint32(inst.len)
 
-		// Count locals from routine header
-		numLocals := m.mem[routineAddr]
-		if numLocals > 15 {
-			panic(fmt.Sprintf("Attempted to call routine at %08x with invalid local count %d", routineAddr, numLocals))
+	// THROW, returns from the frame given by an earlier CATCH
+	case 0x1C, 0x3C, 0x5C, 0x7C, 0xDC:
+		val := inst.operands[0]
+		frameNum := int(inst.operands[1])
+		if frameNum < 1 || frameNum > len(m.callStack) {
+			m.reportError(ERR_INVALID_FRAME, "throw to frame %d, the call stack is %d deep", frameNum, len(m.callStack))
+			m.pc += uint32(inst.len)
+			return
 		}
 
-		m.debug(" - call to %08x with %d locals\n", routineAddr, numLocals)
-
-		// Push new stack frame
-		frame := m.addCallFrame()
-		frame.ReturnAddr = m.pc + uint32(inst.len)
-
-		// Populate locals (word sized) from the routine header
-		// Note: Many compilers don't initialize locals, so this step may be unnecessary
-		for i := byte(0); i < numLocals; i++ {
-			localVal := decode.GetWord32(m.mem, routineAddr+1+uint32(i*2))
-			m.trace(" - local init %d = %d\n", i, localVal)
-			frame.Locals[i] = localVal
-		}
+		m.callStack = m.callStack[:frameNum]
+		m.returnFromCall(val)
 
-		if len(inst.operands) > 1 {
-			// Push arguments into local variables
-			for i, argVal := range inst.operands[1:] {
-				frame.Locals[i] = argVal
-				m.trace(" - arg %d = %d\n", i, argVal)
-			}
-		}
+	// ===================== VAR INSTRUCTIONS =====================
 
-		// Set PC to start of routine after header and locals
-		m.pc = routineAddr + 1 + uint32(numLocals*2)
+	// CALL aka CALL_VS in v4+
+	case 0xE0:
+		m.callRoutine(inst.operands[0], inst.operands[1:], m.pc+uint32(inst.len), false)
 
 	// STOREW
 	case 0xE1:
@@ -551,119 +567,78 @@ func (m *Machine) step() {
 
 	// PUT_PROP
 	case 0xE3:
-		objNum := byte(inst.operands[0])
+		objNum := inst.operands[0]
 		propNum := byte(inst.operands[1])
 		val := inst.operands[2]
 		obj := m.getObject(objNum)
 		obj.setPropertyValue(propNum, val)
 		m.pc += uint32(inst.len)
 
-	// SREAD aka READ in v3
+	// SREAD aka READ in v3, and AREAD in v5 where it also stores the key which ended input
 	case 0xE4:
 		textAddr := inst.operands[0]
-		parseAddr := inst.operands[1]
-		maxLen := m.mem[textAddr]
-		if maxLen == 0 {
+		parseAddr := uint16(0)
+		if len(inst.operands) > 1 {
+			parseAddr = inst.operands[1]
+		}
+
+		if m.mem[textAddr] == 0 {
 			panic("READ called with zero max length")
 		}
-		maxLen-- // Weirdly, the first byte is the max length, so reduce by 1 for actual input
 
-		// Read input from user
-		input := m.readString()
-		input = strings.ToLower(input)
-		input = strings.Trim(input, "\r\n")
+		// Snapshot the state before every read so the turn can be undone
+		m.pushUndo(inst)
+
+		// The status line must be redrawn before input is taken
+		// See: https://zspec.jaredreisinger.com/10-input#10_5_1
 		m.showStatus()
+		m.flushUpperWindow()
 
-		// Copy input into memory at textAddr, and null terminate, important!
-		copy(m.mem[textAddr+1:textAddr+uint16(maxLen)], input)
-		m.mem[textAddr+1+uint16(len(input))] = 0
-
-		// Tokenize the input string by dictionary separators
-		var tokens []string
-		currentWord := ""
-
-		for _, char := range input {
-			// Check if this character is a separator
-			isSep := false
-			for _, sep := range m.dictSep {
-				if char == rune(sep[0]) {
-					isSep = true
-					break
-				}
-			}
+		// From v4 the read can be timed, calling a routine every so many tenths of a second
+		timeout, routine := timedInput(inst.operands, 2)
 
-			if isSep {
-				// Add current word if not empty
-				if currentWord != "" {
-					tokens = append(tokens, currentWord)
-					currentWord = ""
-				}
-				// Add separator as a token (but not spaces)
-				if char != ' ' {
-					tokens = append(tokens, string(char))
-				}
-			} else if char == ' ' {
-				// Space ends a word but is not added as a token
-				if currentWord != "" {
-					tokens = append(tokens, currentWord)
-					currentWord = ""
-				}
-			} else {
-				// Regular character, add to current word
-				currentWord += string(char)
+		// Read input from user
+		m.stateReplaced = false
+		terminator := uint16(KEY_NEWLINE)
+		input, ok := m.readString(timeout)
+		for !ok {
+			// When the routine returns true input is abandoned, as if nothing had been typed
+			if m.callInterrupt(routine) != 0 || m.exitCode != 0 {
+				m.ext.AbandonInput()
+				input = ""
+				terminator = 0
+				break
 			}
-		}
 
-		// Don't forget the last word
-		if currentWord != "" {
-			tokens = append(tokens, currentWord)
+			m.flushUpperWindow()
+			input, ok = m.readString(timeout)
 		}
 
-		// Now we have tokens, look them up in the dictionary
-		dictHits := []dictEntry{}
-		for _, token := range tokens {
-			dictHits = append(dictHits, m.lookupWordInDict(token))
+		// A restore while waiting for input has moved the PC elsewhere, so abandon this read
+		if m.stateReplaced {
+			return
 		}
 
-		// Write token count to parse table, after max tokens byte
-		m.mem[parseAddr+1] = byte(len(dictHits))
-
-		// Write each dictionary entry to parse table in memory, each is 4 bytes
-		parseOffset := parseAddr + 2 // Skip max tokens & count bytes
-		for i, dictHit := range dictHits {
-			entryOffset := parseOffset + uint16(i*4)
-
-			// Write dictionary address (2 bytes)
-			decode.SetWord(m.mem, entryOffset, dictHit.address)
+		input = strings.ToLower(input)
+		input = strings.Trim(input, "\r\n")
+		m.writeTextBuffer(textAddr, input)
 
-			// Write word length (1 byte)
-			word := dictHit.word
-			if dictHit.address == 0 {
-				word = tokens[i] // Use original token if not found
-			}
-			m.mem[entryOffset+2] = byte(len(word))
-
-			// Write position in text buffer (1 byte), 1-based index
-			// Need to find the position of the word in the original input
-			position := byte(0)
-			searchOffset := uint16(1) // Start after max length byte
-			for pos, _ := range input {
-				if strings.HasPrefix(input[searchOffset-1:], word) {
-					position = byte(pos + 1) // 1-based index
-					break
-				}
-				searchOffset++
-			}
-			m.mem[entryOffset+3] = position
+		// From v5 the parse table is optional, with no table the text isn't split into words
+		if parseAddr != 0 {
+			m.tokenise(textAddr, parseAddr, m.dict, false)
 		}
 
-		m.pc += uint32(inst.len)
+		if m.version >= 5 {
+			dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
+			m.storeVar(uint16(dest), terminator)
+			m.pc += uint32(inst.len) + 1 // +1 for dest byte
+		} else {
+			m.pc += uint32(inst.len)
+		}
 
 	// PRINT_CHAR
 	case 0xE5:
-		charCode := byte(inst.operands[0])
-		r := decode.ZSCIIChar(charCode)
-		m.print(string(r))
+		m.print(m.text.ZSCIIChar(inst.operands[0]))
 		m.pc += uint32(inst.len)
 
 	// PRINT_NUM
@@ -677,11 +652,10 @@ func (m *Machine) step() {
 		maxVal := int16(inst.operands[0])
 		var result uint16
 		if maxVal <= 0 {
-			// Reseed random number generator
-			m.rand = rand.New(rand.NewPCG(uint64(maxVal), 0))
-			result = 0
+			// Reseed random number generator, this always stores 0
+			m.rand.seed(uint16(-maxVal))
 		} else {
-			result = uint16(m.rand.IntN(int(maxVal)))
+			result = m.rand.next(uint16(maxVal))
 		}
 		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
 		m.storeVar(uint16(dest), result)
@@ -695,31 +669,312 @@ func (m *Machine) step() {
 
 	// PULL
 	case 0xE9:
-		val := m.getCallFrame().Pop()
+		val := m.popStack()
 		varLoc := inst.operands[0]
 		// TODO: REMOVE
 		//m.setVarInPlace(varLoc, val)
 		m.storeVar(varLoc, val)
 		m.pc += uint32(inst.len)
 
+	// SPLIT_WINDOW
+	case 0xEA:
+		lines := int(inst.operands[0])
+		m.debug(" - split window with %d lines\n", lines)
+		m.splitWindow(lines)
+		m.pc += uint32(inst.len)
+
+	// SET_WINDOW
+	case 0xEB:
+		window := int(inst.operands[0])
+		m.debug(" - set window %d\n", window)
+		m.setWindow(window)
+		m.pc += uint32(inst.len)
+
+	// CALL_VS2
+	case 0xEC:
+		m.callRoutine(inst.operands[0], inst.operands[1:], m.pc+uint32(inst.len), false)
+
+	// ERASE_WINDOW
+	case 0xED:
+		window := int(int16(inst.operands[0]))
+		m.debug(" - erase window %d\n", window)
+		m.eraseWindow(window)
+		m.pc += uint32(inst.len)
+
+	// ERASE_LINE
+	case 0xEE:
+		m.eraseLine(int(inst.operands[0]))
+		m.pc += uint32(inst.len)
+
+	// SET_CURSOR
+	case 0xEF:
+		line := int(int16(inst.operands[0]))
+		column := int(int16(inst.operands[1]))
+		m.debug(" - set cursor line:%d column:%d\n", line, column)
+		m.setCursor(line, column)
+		m.pc += uint32(inst.len)
+
+	// GET_CURSOR
+	case 0xF0:
+		arrayAddr := inst.operands[0]
+		line, column := m.getCursor()
+		decode.SetWord(m.mem, arrayAddr, uint16(line))
+		decode.SetWord(m.mem, arrayAddr+2, uint16(column))
+		m.pc += uint32(inst.len)
+
+	// SET_TEXT_STYLE
+	case 0xF1:
+		style := int(inst.operands[0])
+		m.debug(" - set text style %d\n", style)
+		m.setTextStyle(style)
+		m.pc += uint32(inst.len)
+
+	// BUFFER_MODE
+	case 0xF2:
+		m.screen.buffered = inst.operands[0] != 0
+		m.pc += uint32(inst.len)
+
 	// OUTPUT_STREAM
 	case 0xF3:
-		panic("NOT_IMPLEMENTED: OUTPUT_STREAM")
+		stream := int16(inst.operands[0])
+		table := uint16(0)
+		if len(inst.operands) > 1 {
+			table = inst.operands[1]
+		}
+		m.debug(" - output stream %d table:%04x\n", stream, table)
+		m.selectStream(stream, table)
+		m.pc += uint32(inst.len)
+
+	// INPUT_STREAM
+	case 0xF4:
+		stream := int(inst.operands[0])
+		m.debug(" - input stream %d\n", stream)
+		m.SetInputStream(stream)
+		m.pc += uint32(inst.len)
 
 	// SOUND_EFFECT
 	case 0xF5:
-		soundID := inst.operands[0]
-		effect := inst.operands[1]
-		volume := 0
+		// All the operands are optional, with none at all it's a bleep
+		// The v5 routine to call when the sound finishes isn't supported, as we can't tell when it has
+		var ops [3]uint16
+		copy(ops[:], inst.operands)
+		m.debug(" - sound number:%d effect:%d volume:%04x\n", ops[0], ops[1], ops[2])
+		m.soundEffect(ops[0], ops[1], ops[2])
+		m.pc += uint32(inst.len)
+
+	// READ_CHAR
+	case 0xF6:
+		// The first operand is always 1, the keyboard
+		timeout, routine := timedInput(inst.operands, 1)
+		m.flushUpperWindow()
+
+		key, ok := m.readChar(timeout)
+		for !ok {
+			// When the routine returns true the read is abandoned and 0 is returned
+			if m.callInterrupt(routine) != 0 || m.exitCode != 0 {
+				key = 0
+				break
+			}
+
+			m.flushUpperWindow()
+			key, ok = m.readChar(timeout)
+		}
+
+		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
+		m.storeVar(uint16(dest), key)
+		m.pc += uint32(inst.len) + 1 // +1 for dest byte
+
+	// SCAN_TABLE
+	case 0xF7:
+		val := inst.operands[0]
+		tableAddr := inst.operands[1]
+		length := inst.operands[2]
+		form := uint16(0x82) // Default is a table of words
+		if len(inst.operands) > 3 {
+			form = inst.operands[3]
+		}
+
+		found := m.scanTable(val, tableAddr, length, form)
+		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
+		m.storeVar(uint16(dest), found)
+		m.branchHandler(inst.len+1, found != 0)
+
+	// NOT (BITWISE) in v5
+	case 0xF8:
+		v := inst.operands[0]
+		varLoc := m.mem[m.pc+uint32(inst.len)]
+		m.storeVar(uint16(varLoc), ^v)
+		m.pc += uint32(inst.len) + 1 // +1 for dest byte
+
+	// CALL_VN & CALL_VN2
+	case 0xF9, 0xFA:
+		m.callRoutine(inst.operands[0], inst.operands[1:], m.pc+uint32(inst.len), true)
+
+	// TOKENISE
+	case 0xFB:
+		textAddr := inst.operands[0]
+		parseAddr := inst.operands[1]
+		dict := m.dict
+		if len(inst.operands) > 2 && inst.operands[2] != 0 {
+			dict = m.loadDictionary(inst.operands[2])
+		}
+		skipUnknown := len(inst.operands) > 3 && inst.operands[3] != 0
+
+		m.tokenise(textAddr, parseAddr, dict, skipUnknown)
+		m.pc += uint32(inst.len)
+
+	// ENCODE_TEXT
+	case 0xFC:
+		textAddr := inst.operands[0]
+		length := inst.operands[1]
+		from := inst.operands[2]
+		codedAddr := inst.operands[3]
+
+		text := m.text.ZSCIIString(m.mem[textAddr+from : textAddr+from+length])
+		for i, word := range m.text.Encode(text) {
+			decode.SetWord(m.mem, codedAddr+uint16(i*2), word)
+		}
+		m.pc += uint32(inst.len)
+
+	// COPY_TABLE
+	case 0xFD:
+		m.copyTable(inst.operands[0], inst.operands[1], int16(inst.operands[2]))
+		m.pc += uint32(inst.len)
+
+	// PRINT_TABLE
+	case 0xFE:
+		textAddr := inst.operands[0]
+		width := inst.operands[1]
+		height, skip := uint16(1), uint16(0)
 		if len(inst.operands) > 2 {
-			volume = int(inst.operands[2])
+			height = inst.operands[2]
+		}
+		if len(inst.operands) > 3 {
+			skip = inst.operands[3]
+		}
+
+		m.printTable(textAddr, width, height, skip)
+		m.pc += uint32(inst.len)
+
+	// CHECK_ARG_COUNT
+	case 0xFF:
+		argNum := inst.operands[0]
+		m.branchHandler(inst.len, argNum <= uint16(m.getCallFrame().ArgCount))
+
+	// Unimplemented instruction!
+	default:
+		panic(fmt.Sprintf("unimplemented instruction: %02x", inst.code))
+	}
+}
+
+// stepExtended executes the v5 extended instructions, which all have a 0xBE prefix
+func (m *Machine) stepExtended(inst instruction) {
+	switch inst.code {
+	// SAVE & RESTORE, the optional operands are for saving parts of memory which we don't support
+	case 0x00, 0x01:
+		if len(inst.operands) > 0 {
+			m.debug(" - auxiliary file save/restore isn't supported\n")
+			dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
+			m.storeVar(uint16(dest), 0)
+			m.pc += uint32(inst.len) + 1 // +1 for dest byte
+			return
+		}
+
+		ok := false
+		if inst.code == 0x00 {
+			ok = m.saveGame(m.pc + uint32(inst.len))
+		} else {
+			// On success the PC has already moved on to wherever the save was made
+			if m.restoreGame() {
+				return
+			}
+		}
+
+		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
+		m.storeVar(uint16(dest), boolToWord(ok))
+		m.pc += uint32(inst.len) + 1 // +1 for dest byte
+
+	// LOG_SHIFT & ART_SHIFT, a positive number of places shifts left, negative shifts right
+	case 0x02, 0x03:
+		val := inst.operands[0]
+		places := int16(inst.operands[1])
+
+		var result uint16
+		switch {
+		case places >= 0:
+			result = val << places
+		case inst.code == 0x02:
+			result = val >> -places
+		default:
+			result = uint16(int16(val) >> -places)
+		}
+
+		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
+		m.storeVar(uint16(dest), result)
+		m.pc += uint32(inst.len) + 1 // +1 for dest byte
+
+	// SET_FONT
+	case 0x04:
+		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
+		m.storeVar(uint16(dest), m.setFont(int(inst.operands[0])))
+		m.pc += uint32(inst.len) + 1 // +1 for dest byte
+
+	// SAVE_UNDO
+	case 0x09:
+		// The snapshot carries on from our store byte, which restore_undo sets to 2
+		m.saveUndo(m.pc + uint32(inst.len))
+		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
+		m.storeVar(uint16(dest), 1)
+		m.pc += uint32(inst.len) + 1 // +1 for dest byte
+
+	// RESTORE_UNDO
+	case 0x0A:
+		if !m.restoreUndo() {
+			dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
+			m.storeVar(uint16(dest), 0)
+			m.pc += uint32(inst.len) + 1 // +1 for dest byte
 		}
-		m.debug(" - play sound ID:%d effect:%d volume:%d\n", soundID, effect, volume)
-		m.ext.PlaySound(soundID, effect, uint16(volume))
+
+	// PRINT_UNICODE
+	case 0x0B:
+		m.print(string(rune(inst.operands[0])))
 		m.pc += uint32(inst.len)
 
+	// CHECK_UNICODE, bit 0 is set if we can print the character and bit 1 if it can be typed
+	case 0x0C:
+		r := rune(inst.operands[0])
+		result := uint16(0)
+		if r >= 32 && utf8.ValidRune(r) {
+			result = 1
+		}
+		if _, ok := m.text.ZSCIICode(r); ok {
+			result |= 2
+		}
+
+		dest := m.mem[m.pc+uint32(inst.len)] // destination in next byte
+		m.storeVar(uint16(dest), result)
+		m.pc += uint32(inst.len) + 1 // +1 for dest byte
+
 	// Unimplemented instruction!
 	default:
-		panic(fmt.Sprintf("\n💥 Unimplemented instruction: %02x", inst.code))
+		panic(fmt.Sprintf("unimplemented extended instruction: %02x", inst.code))
+	}
+}
+
+// Gets the time and routine operands of a timed read, which start at the given operand
+// The time is in tenths of a second, a zero timeout means the read isn't timed
+// See: https://zspec.jaredreisinger.com/15-opcodes#read
+func timedInput(operands []uint16, first int) (time.Duration, uint16) {
+	if len(operands) < first+2 || operands[first] == 0 || operands[first+1] == 0 {
+		return 0, 0
+	}
+
+	return time.Duration(operands[first]) * 100 * time.Millisecond, operands[first+1]
+}
+
+func boolToWord(b bool) uint16 {
+	if b {
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,198 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// streams.go - Input and output streams
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/benc-uk/gozm/internal/decode"
+)
+
+// outputStreams holds the set of selected output streams
+// The transcript (stream 2) has no field here, its state lives in bit 0 of Flags 2
+// so that games which set the bit directly are always in sync with us
+// See: https://zspec.jaredreisinger.com/07-output
+type outputStreams struct {
+	screen   bool          // Stream 1
+	memory   []memoryTable // Stream 3, nested tables with the innermost last
+	commands bool          // Stream 4
+}
+
+// memoryTable is a table in dynamic memory being written to by stream 3
+type memoryTable struct {
+	addr  uint16 // Address of the table, the first word will hold the length
+	count uint16 // Number of characters written so far
+}
+
+// selectStream handles the output_stream opcode, positive numbers select a stream
+// and negative numbers deselect it, the table is only used by stream 3
+func (m *Machine) selectStream(stream int16, table uint16) {
+	switch stream {
+	case 0:
+		// Nothing happens
+
+	case OUTPUT_STREAM_SCREEN:
+		m.streams.screen = true
+	case -OUTPUT_STREAM_SCREEN:
+		m.streams.screen = false
+
+	case OUTPUT_STREAM_TRANSCRIPT:
+		m.mem[0x11] |= 0x01
+	case -OUTPUT_STREAM_TRANSCRIPT:
+		m.mem[0x11] &^= 0x01
+		m.ext.CloseStream(OUTPUT_STREAM_TRANSCRIPT)
+
+	case OUTPUT_STREAM_MEMORY:
+		if len(m.streams.memory) >= OUTPUT_STREAM_MEMORY_MAX {
+			m.reportError(ERR_STREAM_NESTING, "selected with a table at %04X, it's already %d deep", table, OUTPUT_STREAM_MEMORY_MAX)
+			return
+		}
+		m.streams.memory = append(m.streams.memory, memoryTable{addr: table})
+
+	case -OUTPUT_STREAM_MEMORY:
+		n := len(m.streams.memory)
+		if n == 0 {
+			return // Not selected, nothing to close
+		}
+
+		// The length is only written once the stream is deselected, then the previous table resumes
+		closing := m.streams.memory[n-1]
+		decode.SetWord(m.mem, closing.addr, closing.count)
+		m.streams.memory = m.streams.memory[:n-1]
+
+	case OUTPUT_STREAM_COMMANDS:
+		m.streams.commands = true
+	case -OUTPUT_STREAM_COMMANDS:
+		m.streams.commands = false
+		m.ext.CloseStream(OUTPUT_STREAM_COMMANDS)
+
+	default:
+		m.debug(" - Unknown output stream %d\n", stream)
+	}
+}
+
+// Is the transcript stream selected, from bit 0 of Flags 2
+func (m *Machine) transcriptOn() bool {
+	return m.mem[0x11]&0x01 != 0
+}
+
+// Writes text into the innermost stream 3 table as ZSCII, newlines are written as 13
+// and characters with no ZSCII code as question marks
+func (m *Machine) writeMemoryStream(s string) {
+	table := &m.streams.memory[len(m.streams.memory)-1]
+	for _, r := range s {
+		code, _ := m.text.ZSCIICode(r)
+		m.mem[table.addr+2+table.count] = byte(code)
+		table.count++
+	}
+}
+
+// Sends a player's command to the streams which echo input, the screen is
+// left out as the frontend echoes what was typed there itself
+func (m *Machine) echoCommand(input string) {
+	command := strings.TrimRight(input, "\r\n")
+
+	if m.transcriptOn() {
+		m.ext.TranscriptOut(command + "\n")
+	}
+
+	if m.streams.commands {
+		m.ext.CommandOut(command)
+	}
+}
+
+// SetInputStream switches between the keyboard and a script file of commands
+// The frontend is asked for the script, if there isn't one we stay on the keyboard
+// See: https://zspec.jaredreisinger.com/10-input#10_2
+func (m *Machine) SetInputStream(stream int) {
+	switch stream {
+	case INPUT_STREAM_KEYBOARD:
+		m.closeScript()
+
+	case INPUT_STREAM_FILE:
+		if m.script != nil {
+			return // Already reading a script
+		}
+
+		r := m.ext.OpenScript(m.name)
+		if r == nil {
+			m.debug(" - No command script available, staying on keyboard\n")
+			return
+		}
+
+		m.script = bufio.NewScanner(r)
+		m.scriptReader = r
+		m.inputStream = INPUT_STREAM_FILE
+
+	default:
+		m.debug(" - Unknown input stream %d\n", stream)
+	}
+}
+
+// Reads a line of input from the current input stream, includes the newline
+// Returns false if the timeout expired before a line was entered, scripts never time out
+func (m *Machine) readLine(timeout time.Duration) (string, bool) {
+	if len(m.queuedInput) > 0 {
+		line := m.queuedInput[0]
+		m.queuedInput = m.queuedInput[1:]
+		if m.streams.screen && len(m.streams.memory) == 0 {
+			m.ext.TextOut(line + "\n")
+		}
+
+		return line + "\n", true
+	}
+
+	if m.inputStream == INPUT_STREAM_FILE {
+		if m.script.Scan() {
+			line := m.script.Text()
+
+			// Show the command as if it had been typed, the frontend only echoes real typing
+			if m.streams.screen && len(m.streams.memory) == 0 {
+				m.ext.TextOut(line + "\n")
+			}
+
+			return line + "\n", true
+		}
+
+		// At the end of the script we fall back to the keyboard
+		m.debug("End of command script, switching to keyboard\n")
+		m.closeScript()
+	}
+
+	return m.ext.ReadInput(timeout)
+}
+
+// Reads a single key for read_char as a ZSCII code, this always comes from the keyboard
+func (m *Machine) readChar(timeout time.Duration) (uint16, bool) {
+	key, ok := m.ext.ReadChar(timeout)
+	if !ok {
+		return 0, false
+	}
+
+	// The special keys are already given as their ZSCII codes, anything else needs mapping
+	switch key {
+	case KEY_DELETE, KEY_ESCAPE, KEY_UP, KEY_DOWN, KEY_LEFT, KEY_RIGHT:
+		return uint16(key), true
+	}
+
+	code, _ := m.text.ZSCIICode(key)
+	return code, true
+}
+
+func (m *Machine) closeScript() {
+	if closer, ok := m.scriptReader.(io.Closer); ok {
+		_ = closer.Close()
+	}
+
+	m.script = nil
+	m.scriptReader = nil
+	m.inputStream = INPUT_STREAM_KEYBOARD
+}
@@ -0,0 +1,61 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// tables.go - Searching and copying tables in memory, for the v5 table opcodes
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+import "github.com/benc-uk/gozm/internal/decode"
+
+// scanTable looks for a value in a table of length fields, returning the address of the field or 0
+// The top bit of form says the fields start with a word rather than a byte, the rest is the field size
+// See: https://zspec.jaredreisinger.com/15-opcodes#scan_table
+func (m *Machine) scanTable(val uint16, tableAddr uint16, length uint16, form uint16) uint16 {
+	fieldLen := form & 0x7F
+	words := form&0x80 != 0
+
+	addr := tableAddr
+	for i := uint16(0); i < length; i++ {
+		if words && decode.GetWord(m.mem, addr) == val {
+			return addr
+		}
+		if !words && uint16(m.mem[addr]) == val {
+			return addr
+		}
+
+		addr += fieldLen
+	}
+
+	return 0
+}
+
+// copyTable copies size bytes from first to second, if second is 0 then first is zeroed instead
+// A negative size means copy forwards even if the tables overlap, which can smear the data
+// See: https://zspec.jaredreisinger.com/15-opcodes#copy_table
+func (m *Machine) copyTable(first uint16, second uint16, size int16) {
+	if second == 0 {
+		for i := uint16(0); i < uint16(abs(size)); i++ {
+			m.mem[first+i] = 0
+		}
+		return
+	}
+
+	if size < 0 {
+		for i := uint16(0); i < uint16(-size); i++ {
+			m.mem[second+i] = m.mem[first+i]
+		}
+		return
+	}
+
+	// Go's copy is safe when the tables overlap
+	copy(m.mem[second:second+uint16(size)], m.mem[first:first+uint16(size)])
+}
+
+func abs(n int16) int16 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,82 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// tokenise.go - Splitting the text in a text buffer into words for the parse table
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+import (
+	"bytes"
+
+	"github.com/benc-uk/gozm/internal/decode"
+)
+
+// token is a word found in the input, with where it started so the parse table can point back at it
+type token struct {
+	text []byte // The word as ZSCII
+	pos  int    // Offset of the first character from the start of the text
+}
+
+// splitWords breaks ZSCII text into words, spaces separate words and are dropped, while the
+// dictionary's separator characters separate words and are also words themselves
+// See: https://zspec.jaredreisinger.com/13-dictionary#13_6_1
+func splitWords(text []byte, sep []byte) []token {
+	tokens := []token{}
+	start := -1
+
+	for i, c := range text {
+		isSep := bytes.IndexByte(sep, c) >= 0
+		if c == ' ' || isSep {
+			if start >= 0 {
+				tokens = append(tokens, token{text: text[start:i], pos: start})
+				start = -1
+			}
+			if isSep && c != ' ' {
+				tokens = append(tokens, token{text: text[i : i+1], pos: i})
+			}
+			continue
+		}
+
+		if start < 0 {
+			start = i
+		}
+	}
+
+	// Don't forget the last word
+	if start >= 0 {
+		tokens = append(tokens, token{text: text[start:], pos: start})
+	}
+
+	return tokens
+}
+
+// tokenise splits the text in a text buffer into words, looks them up in the dictionary and
+// fills in the parse table with the results, stopping when the table is full. If skipUnknown is
+// set then entries for words not in the dictionary are left alone, so a second dictionary can
+// fill in the gaps. Each parse table entry is 4 bytes, the dictionary address of the word, its
+// length and its position in the text buffer
+// See: https://zspec.jaredreisinger.com/15-opcodes#read
+func (m *Machine) tokenise(textAddr uint16, parseAddr uint16, dict *dictionary, skipUnknown bool) {
+	tokens := splitWords(m.readTextBuffer(textAddr), dict.sep)
+
+	// The first byte of the parse table is the most words it can hold
+	maxTokens := int(m.mem[parseAddr])
+	if len(tokens) > maxTokens {
+		tokens = tokens[:maxTokens]
+	}
+	m.mem[parseAddr+1] = byte(len(tokens))
+
+	for i, t := range tokens {
+		entryAddr := parseAddr + 2 + uint16(i*4)
+		dictAddr := dict.lookup(m.text.ZSCIIString(t.text), &m.text)
+		if dictAddr == 0 && skipUnknown {
+			continue
+		}
+
+		decode.SetWord(m.mem, entryAddr, dictAddr)
+		m.mem[entryAddr+2] = byte(len(t.text))
+		m.mem[entryAddr+3] = byte(t.pos) + byte(m.textStart()) // Counted from the start of the buffer
+	}
+}
@@ -0,0 +1,143 @@
+// =======================================================================
+// Package: zmachine - Core Z-machine interpreter
+// undo.go - Multi-level undo, using a ring of snapshots
+//
+// Copyright (c) 2025 Ben Coleman. Licensed under the MIT License
+// =======================================================================
+
+package zmachine
+
+// undoEntry is a single snapshot in the undo ring
+// To keep many levels cheap only the newest snapshot holds a full copy of memory,
+// every older entry holds a delta which turns the next snapshot's memory into its own
+type undoEntry struct {
+	pc        uint32
+	callStack []CallFrame
+	memDelta  []byte // XOR & run length encoded, in the same way as Quetzal CMem
+}
+
+// undoRing is a bounded stack of snapshots, the oldest are dropped once it's full
+type undoRing struct {
+	entries []undoEntry
+	lastMem []byte // Full copy of dynamic memory for the newest entry
+}
+
+// pushUndo takes a snapshot of the machine, with the PC at the current instruction
+// so that undoing back to it will run that instruction again. Any operands it took
+// from the stack are put back in the snapshot, as decoding it again will pop them
+func (m *Machine) pushUndo(inst instruction) {
+	m.undo.push(m, m.pc)
+
+	entry := &m.undo.entries[len(m.undo.entries)-1]
+	frame := &entry.callStack[len(entry.callStack)-1]
+	for i := len(inst.popped) - 1; i >= 0; i-- {
+		frame.Push(inst.popped[i])
+	}
+}
+
+// popUndo removes the newest snapshot and returns it as a full SaveState
+func (m *Machine) popUndo() *SaveState {
+	return m.undo.pop(m.name)
+}
+
+// push adds a snapshot of the machine to the ring, which will carry on from pc when restored
+func (u *undoRing) push(m *Machine, pc uint32) {
+	mem := m.mem[:m.staticAddr]
+
+	// The previous newest entry now needs a delta, as it's losing its full copy of memory
+	if len(u.entries) > 0 {
+		u.entries[len(u.entries)-1].memDelta = compressMem(u.lastMem, mem)
+	}
+
+	u.entries = append(u.entries, undoEntry{
+		pc:        pc,
+		callStack: copyCallStack(m.callStack),
+	})
+	u.lastMem = append(u.lastMem[:0], mem...)
+
+	if len(u.entries) > UNDO_LEVELS {
+		u.entries = u.entries[1:]
+	}
+}
+
+// pop removes the newest snapshot and returns it as a full SaveState, nil if there are none
+func (u *undoRing) pop(name string) *SaveState {
+	n := len(u.entries)
+	if n == 0 {
+		return nil
+	}
+
+	entry := u.entries[n-1]
+	u.entries = u.entries[:n-1]
+	state := &SaveState{
+		PC:        entry.pc,
+		CallStack: entry.callStack,
+		Mem:       u.lastMem,
+		Name:      name,
+	}
+
+	// Rebuild the full memory of what is now the newest entry from its delta
+	u.lastMem = nil
+	if n > 1 {
+		prev := &u.entries[n-2]
+		prevMem, err := decompressMem(prev.memDelta, state.Mem)
+		if err != nil {
+			// Should never happen with deltas we made, but the older entries are now useless
+			u.entries = u.entries[:0]
+			return state
+		}
+		u.lastMem = prevMem
+		prev.memDelta = nil
+	}
+
+	return state
+}
+
+// Undo rolls the game back one turn, returns false if there is nothing to undo
+// A snapshot is taken before every read, so the newest one is the turn in progress
+// and the one before it is where we want to go back to
+func (m *Machine) Undo() bool {
+	if len(m.undo.entries) < 2 {
+		return false
+	}
+
+	m.popUndo()
+	state := m.popUndo()
+	m.ReplaceState(state)
+	m.stateReplaced = true
+
+	return true
+}
+
+// saveUndo handles the v5 save_undo opcode, pc points at its store byte
+// These snapshots are kept apart from the ones taken before each read, which /undo uses
+func (m *Machine) saveUndo(pc uint32) {
+	m.gameUndo.push(m, pc)
+}
+
+// restoreUndo handles the v5 restore_undo opcode, returns false if there was no snapshot
+// On success the machine carries on from the save_undo, which now stores 2
+func (m *Machine) restoreUndo() bool {
+	state := m.gameUndo.pop(m.name)
+	if state == nil {
+		return false
+	}
+
+	m.ReplaceState(state)
+	m.storeVar(uint16(m.mem[m.pc]), 2)
+	m.pc++
+
+	return true
+}
+
+// Deep copy of a call stack, so a snapshot isn't changed as the machine runs
+func copyCallStack(stack []CallFrame) []CallFrame {
+	out := make([]CallFrame, len(stack))
+	for i, frame := range stack {
+		out[i] = frame
+		out[i].Locals = append([]uint16{}, frame.Locals...)
+		out[i].Stack = append([]uint16{}, frame.Stack...)
+	}
+
+	return out
+}
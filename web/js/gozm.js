@@ -5,7 +5,7 @@
 
 import { version } from './version.js'
 import { initMenus } from './menus.js'
-import { initInput, requestInput, removeInputDisplay } from './input.js'
+import { initInput, requestInput, requestChar, removeInputDisplay, abandonInput, redrawInput } from './input.js'
 
 const MAX_OUTBUFFER = 8000
 
@@ -13,34 +13,67 @@ const MAX_OUTBUFFER = 8000
 const go = new Go()
 let prefs = {}
 let outArea
+let statusBar
+let upperWindow
 let modal
+let transcript = '' // Output stream 2, only written to when the game turns on scripting
+let commands = [] // Output stream 4, the player's commands
+let sound = null // Sound effect that's playing
+let colours = { fg: 1, bg: 1 } // Colours for the output area, 1 is the theme's own
+
+// CSS colours for the Z-machine colour numbers, 0 & 1 aren't colours as such
+const colourNames = [null, null, 'black', 'red', 'green', 'yellow', 'blue', 'magenta', 'cyan', 'white']
 
 // Two way bridge between Go and JS
 window.bridge = {
   textOut: textOut,
   requestInput: requestInput,
+  requestChar: requestChar,
+  abandonInput: abandonInput,
+  clearScreen: clearScreen,
+  setTextStyle: setTextStyle,
+  setColour: setColour,
   loadedFile: loadedFile,
   playSound: playSound,
+  stopSound: stopSound,
+  bleep: bleep,
+  transcriptOut: transcriptOut,
+  commandOut: commandOut,
+  showStatus: showStatus,
+  showUpperWindow: showUpperWindow,
+  showModal: showModal,
+  showCover: showCover,
   // These are stubs to be replaced by Go when the module is running
   save: null,
   load: null,
+  undo: null,
   getInfo: null,
   inputSend: null,
+  charSend: null,
   receiveFileData: null,
 }
 
 // When DOM is loaded, initialize everything, this is our entry point
 window.addEventListener('DOMContentLoaded', async () => {
   outArea = document.querySelector('pre')
+  statusBar = document.getElementById('status')
+  upperWindow = document.getElementById('upper')
   modal = document.getElementById('modal')
   const hiddenInput = document.getElementById('hiddenInput')
   initMenus()
 
   // Initialize input handling with submit callback
-  initInput(outArea, hiddenInput, (text) => {
-    // textOut(text + '\n')
-    bridge.inputSend(text)
-  })
+  initInput(
+    outArea,
+    hiddenInput,
+    (text) => {
+      // textOut(text + '\n')
+      bridge.inputSend(text)
+    },
+    (key) => {
+      bridge.charSend(key)
+    },
+  )
 
   // detect resizes to adjust scroll
   window.addEventListener('resize', () => {
@@ -104,13 +137,33 @@ export function textOut(text) {
   // Temporarily remove cursor elements before modifying textContent
   removeInputDisplay()
 
-  outArea.textContent += text
+  // Coloured text goes in a span, anything else is added as it is
+  if (colours.fg > 1 || colours.bg > 1) {
+    const span = document.createElement('span')
+    span.textContent = text
+    if (colours.fg > 1) span.style.color = colourNames[colours.fg]
+    if (colours.bg > 1) span.style.backgroundColor = colourNames[colours.bg]
+    outArea.append(span)
+  } else {
+    outArea.append(text)
+  }
 
-  // Trim output buffer if too large
-  if (outArea.textContent.length > MAX_OUTBUFFER) {
-    outArea.textContent = outArea.textContent.slice(-MAX_OUTBUFFER)
+  // Trim output buffer if too large, the oldest text goes first
+  let excess = outArea.textContent.length - MAX_OUTBUFFER
+  while (excess > 0 && outArea.firstChild) {
+    const first = outArea.firstChild
+    if (first.textContent.length <= excess) {
+      excess -= first.textContent.length
+      first.remove()
+    } else {
+      first.textContent = first.textContent.slice(excess)
+      excess = 0
+    }
   }
 
+  // Timed input can have output part way through typing, which goes above what's been typed
+  redrawInput()
+
   requestAnimationFrame(() => {
     outArea.scrollTop = outArea.scrollHeight
   })
@@ -131,7 +184,7 @@ export function reset() {
 export function promptFile() {
   const input = document.createElement('input')
   input.type = 'file'
-  input.accept = '.z3'
+  input.accept = '.z3,.z4,.z5,.z7,.z8,.zblorb,.zlb'
   input.onchange = async (e) => {
     const file = e.target.files[0]
     if (!file) {
@@ -148,6 +201,8 @@ export function promptFile() {
 // Change theme of the fake terminal UI
 export function setTheme(theme) {
   outArea.className = `theme${theme}`
+  statusBar.className = `theme${theme}`
+  upperWindow.className = `theme${theme}`
   modal.className = `theme${theme}`
 
   prefs.theme = theme
@@ -167,6 +222,8 @@ function loadedFile(filename) {
 // Called from JS to fake a boot sequence
 function boot() {
   clearScreen()
+  statusBar.style.display = 'none'
+  upperWindow.style.display = 'none'
   textOut('System booting...\n')
   textOut('64K dynamic memory available\n')
   textOut('I/O buffers flushed\n\n')
@@ -175,12 +232,128 @@ function boot() {
   textOut('Open a file to begin\n')
 }
 
-function playSound(soundID, effect, vol) {
-  console.log('STUB! Play sound requested:', soundID, effect, vol)
+// Called from Go when the transcript output stream is selected
+function transcriptOut(text) {
+  transcript += text
+}
+
+// Called from Go when the command record output stream is selected
+function commandOut(command) {
+  commands.push(command)
+}
+
+// Download the transcript and any recorded commands as a text file
+export function downloadTranscript() {
+  if (transcript === '' && commands.length === 0) {
+    showModal('No transcript has been recorded.\nUse the SCRIPT command in game to start one.')
+    return
+  }
+
+  let text = transcript
+  if (commands.length > 0) {
+    text += '\n\n--- Recorded commands ---\n' + commands.join('\n') + '\n'
+  }
+
+  const link = document.createElement('a')
+  link.href = URL.createObjectURL(new Blob([text], { type: 'text/plain' }))
+  link.download = `${prefs.loadedFile || 'gozm'}-transcript.txt`
+  link.click()
+  URL.revokeObjectURL(link.href)
+}
+
+// Called from Go before each input in v1-3 games, location on the left and score or time on the right
+function showStatus(location, summary) {
+  statusBar.firstChild.textContent = location
+  statusBar.lastChild.textContent = summary
+  statusBar.style.display = 'flex'
+}
+
+// Called from Go with the contents of the upper window, which is hidden when there are no lines
+// Each line is a list of [text, style] runs, the style is a mix of the Z-machine style bits
+function showUpperWindow(lines) {
+  upperWindow.replaceChildren()
+
+  lines.forEach((runs, i) => {
+    if (i > 0) {
+      upperWindow.append('\n')
+    }
+
+    for (const [text, style] of runs) {
+      const span = document.createElement('span')
+      span.textContent = text
+      span.classList.toggle('reverse', (style & 1) !== 0)
+      span.classList.toggle('bold', (style & 2) !== 0)
+      span.classList.toggle('italic', (style & 4) !== 0)
+      upperWindow.append(span)
+    }
+  })
+
+  upperWindow.style.display = lines.length > 0 ? 'block' : 'none'
+}
+
+// Called from Go when the game changes text style, the output area is plain text so this is ignored
+function setTextStyle(style) {
+  console.log('Text style requested:', style)
+}
+
+// Called from Go when the game changes colours, for the text which follows
+function setColour(fg, bg) {
+  colours = { fg, bg }
+}
+
+// Called from Go to play a sound effect from a Blorb file, only one plays at a time
+// The volume is 1 to 8, and repeats of 255 means play until stopped
+function playSound(number, format, data, volume, repeats) {
+  stopSound()
+
+  const types = { OGGV: 'audio/ogg', AIFF: 'audio/aiff', MOD: 'audio/mod' }
+  const url = URL.createObjectURL(new Blob([data], { type: types[format.trim()] }))
+  sound = new Audio(url)
+  sound.volume = volume / 8
+  sound.loop = repeats === 255
+
+  let plays = 1
+  sound.addEventListener('ended', () => {
+    if (plays++ < repeats) {
+      sound.play()
+    }
+  })
+
+  sound.play().catch((err) => console.warn(`Unable to play sound ${number} (${format}):`, err))
+}
+
+function stopSound() {
+  if (sound) {
+    sound.pause()
+    URL.revokeObjectURL(sound.src)
+    sound = null
+  }
+}
+
+// Called from Go for the two bleeps every game can use, which need no sound data
+function bleep(high) {
+  const ctx = new AudioContext()
+  const osc = ctx.createOscillator()
+  osc.frequency.value = high ? 880 : 220
+  osc.connect(ctx.destination)
+  osc.start()
+  osc.stop(ctx.currentTime + 0.15)
+  osc.onended = () => ctx.close()
 }
 
 export function showModal(message) {
   const modal = document.getElementById('modal')
+  modal.querySelector('img.cover')?.remove()
   modal.firstChild.textContent = message
   modal.style.display = 'block'
 }
+
+// Called from Go with the cover image of a Blorb file
+function showCover(title, mimeType, data) {
+  showModal(title)
+
+  const img = document.createElement('img')
+  img.className = 'cover'
+  img.src = URL.createObjectURL(new Blob([data], { type: mimeType }))
+  modal.insertBefore(img, modal.querySelector('button'))
+}
@@ -11,13 +11,16 @@ let hist = []
 let histIndex = -1
 let inputBuffer = ''
 let inputActive = false
+let charActive = false // Waiting for a single key press, for read_char
 let onSubmit = null // Callback when input is submitted
+let onChar = null // Callback when a single key is pressed
 
 // Initialize input handling
-export function initInput(outputElement, hiddenInputElement, submitCallback) {
+export function initInput(outputElement, hiddenInputElement, submitCallback, charCallback) {
   outArea = outputElement
   hiddenInput = hiddenInputElement
   onSubmit = submitCallback
+  onChar = charCallback
 
   // Capture keyboard input on the document for terminal-style input
   document.addEventListener('keydown', handleKeyDown)
@@ -67,8 +70,71 @@ export function requestInput(history) {
   })
 }
 
+// Called when the game gives up on a timed read, anything typed is thrown away
+export function abandonInput() {
+  inputActive = false
+  inputBuffer = ''
+  removeInputDisplay()
+}
+
+// Puts the input being typed back after the output, as output removes it
+export function redrawInput() {
+  if (inputActive) {
+    updateInputDisplay()
+  }
+}
+
+// Called to wait for a single key press
+export function requestChar() {
+  charActive = true
+
+  if (isMobile && hiddenInput) {
+    hiddenInput.value = ''
+    hiddenInput.focus()
+  } else {
+    outArea.focus()
+  }
+}
+
+// Key codes as the Z-machine expects them, see section 10.7 of the spec
+const specialKeys = {
+  Enter: 13,
+  Backspace: 8,
+  Delete: 8,
+  Escape: 27,
+  ArrowUp: 129,
+  ArrowDown: 130,
+  ArrowLeft: 131,
+  ArrowRight: 132,
+}
+
+// Sends a single key press to Go, returns true if the key was used
+function handleCharKey(key) {
+  let code = specialKeys[key]
+  if (code === undefined && key.length === 1) {
+    code = key.charCodeAt(0)
+  }
+
+  if (code === undefined) {
+    return false
+  }
+
+  charActive = false
+  if (onChar) {
+    onChar(code)
+  }
+  return true
+}
+
 // Handle input from hidden input on mobile
 function handleMobileInput(e) {
+  if (charActive && e.target.value.length > 0) {
+    const key = e.target.value.slice(-1)
+    e.target.value = ''
+    handleCharKey(key)
+    return
+  }
+
   if (!inputActive) return
   inputBuffer = e.target.value
   updateInputDisplay()
@@ -89,11 +155,18 @@ function handleMobileKeyDown(e) {
 
 // Handle keyboard input for terminal-style typing
 function handleKeyDown(e) {
-  if (!inputActive) return
-
   // Don't capture if user is in a menu or other input
   if (e.target.tagName === 'INPUT' || e.target.tagName === 'TEXTAREA') return
 
+  if (charActive && !e.ctrlKey && !e.metaKey && !e.altKey) {
+    if (handleCharKey(e.key)) {
+      e.preventDefault()
+    }
+    return
+  }
+
+  if (!inputActive) return
+
   if (e.key === 'Enter') {
     e.preventDefault()
     submitInput()
@@ -3,7 +3,7 @@
 // Menus JavaScript code
 // ===============================================================
 
-import { openFile, promptFile, setTheme, reset, showModal } from './gozm.js'
+import { openFile, promptFile, setTheme, reset, showModal, downloadTranscript } from './gozm.js'
 import { version } from './version.js'
 
 let fileMenu, sysMenu, prefsMenu, infoMenu
@@ -43,6 +43,9 @@ export function initMenus() {
   addMenuItem(sysMenu, 'Save', () => { bridge.save() }, true)
   //prettier-ignore
   addMenuItem(sysMenu, 'Restore', () => { bridge.load() }, true)
+  //prettier-ignore
+  addMenuItem(sysMenu, 'Undo', () => { bridge.undo() }, true)
+  addMenuItem(sysMenu, 'Download Transcript', () => downloadTranscript(), true)
   addMenuSeparator(sysMenu)
   addMenuItem(sysMenu, 'Reset System', () => {
     reset()
@@ -170,7 +173,8 @@ System commands:
   /quit - Exit the game
   /restart - Restart the game
   /save - Save the game
-  /load - Load a saved game`
+  /load - Load a saved game
+  /undo - Undo the last turn`
   )
 }
 